@@ -0,0 +1,50 @@
+package luddite
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"net/http"
+)
+
+const adminAuthRealm = `Basic realm="admin"`
+
+type adminAuthHandler struct {
+	handler  http.Handler
+	username [sha256.Size]byte
+	password [sha256.Size]byte
+}
+
+// newAdminAuthHandler wraps a handler so that requests must carry HTTP basic
+// authentication credentials matching username and password. Credentials are
+// compared in constant time.
+func newAdminAuthHandler(h http.Handler, username, password string) http.Handler {
+	return &adminAuthHandler{
+		handler:  h,
+		username: sha256.Sum256([]byte(username)),
+		password: sha256.Sum256([]byte(password)),
+	}
+}
+
+func (h *adminAuthHandler) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
+	username, password, ok := req.BasicAuth()
+	if ok {
+		u := sha256.Sum256([]byte(username))
+		p := sha256.Sum256([]byte(password))
+		ok = subtle.ConstantTimeCompare(u[:], h.username[:])&subtle.ConstantTimeCompare(p[:], h.password[:]) == 1
+	}
+	if !ok {
+		rw.Header().Set("WWW-Authenticate", adminAuthRealm)
+		rw.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	h.handler.ServeHTTP(rw, req)
+}
+
+// newAdminHandler returns the handler served on the admin listener.
+func (s *Service) newAdminHandler() http.Handler {
+	var h http.Handler = s.adminRouter
+	if s.config.Admin.Username != "" {
+		h = newAdminAuthHandler(h, s.config.Admin.Username, s.config.Admin.Password)
+	}
+	return h
+}
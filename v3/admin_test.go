@@ -0,0 +1,93 @@
+package luddite
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func testListenerAddr(t *testing.T, s *Service, name string) net.Addr {
+	var addr net.Addr
+	require.Eventually(t, func() bool {
+		s.serverLock.Lock()
+		defer s.serverLock.Unlock()
+		if len(s.servers) == 0 {
+			return false
+		}
+		for _, sl := range s.listeners {
+			if sl.name == name {
+				addr = sl.l.Addr()
+			}
+		}
+		return true
+	}, 5*time.Second, 10*time.Millisecond)
+	require.NotNil(t, addr, "no %s listener", name)
+	return addr
+}
+
+func TestAdminListener(t *testing.T) {
+	config := new(ServiceConfig)
+	config.Addr = "127.0.0.1:0"
+	config.Version.Min = 1
+	config.Version.Max = 1
+	config.Metrics.Enabled = true
+	config.Profiler.Enabled = true
+	config.Admin.Enabled = true
+	config.Admin.Addr = "127.0.0.1:0"
+	config.Admin.Username = "admin"
+	config.Admin.Password = "secret"
+	s, err := NewService(config, &ServiceConfigExt{
+		ServiceLogWriter: io.Discard,
+		AccessLogWriter:  io.Discard,
+	})
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- s.RunContext(ctx) }()
+	publicAddr := testListenerAddr(t, s, "http")
+	adminAddr := testListenerAddr(t, s, "admin")
+
+	get := func(url, username, password string) int {
+		req, err := http.NewRequest("GET", url, nil)
+		require.NoError(t, err)
+		if username != "" {
+			req.SetBasicAuth(username, password)
+		}
+		res, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		_, _ = io.Copy(io.Discard, res.Body)
+		_ = res.Body.Close()
+		return res.StatusCode
+	}
+
+	// Operational endpoints are not served on the public listener
+	require.Equal(t, http.StatusNotFound, get("http://"+publicAddr.String()+"/metrics", "", ""))
+	require.Equal(t, http.StatusNotFound, get("http://"+publicAddr.String()+"/debug/pprof/", "", ""))
+
+	// The admin listener requires credentials
+	require.Equal(t, http.StatusUnauthorized, get("http://"+adminAddr.String()+"/metrics", "", ""))
+	require.Equal(t, http.StatusUnauthorized, get("http://"+adminAddr.String()+"/metrics", "admin", "wrong"))
+	require.Equal(t, http.StatusOK, get("http://"+adminAddr.String()+"/metrics", "admin", "secret"))
+	require.Equal(t, http.StatusOK, get("http://"+adminAddr.String()+"/debug/pprof/", "admin", "secret"))
+
+	cancel()
+	require.NoError(t, <-done)
+}
+
+func TestValidateAdmin(t *testing.T) {
+	config := new(ServiceConfig)
+	config.Version.Min = 1
+	config.Version.Max = 1
+	config.Admin.Enabled = true
+	require.ErrorIs(t, config.Validate(), ErrMissingAdminAddr)
+
+	config.Admin.Addr = ":9090"
+	config.Admin.TLS = true
+	require.ErrorIs(t, config.Validate(), ErrAdminTLSWithoutTLS)
+}
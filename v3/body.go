@@ -19,6 +19,8 @@ const (
 	ContentTypeGrpc              = "application/grpc"
 	ContentTypeHtml              = "text/html"
 	ContentTypeJson              = "application/json"
+	ContentTypeJsonPatch         = "application/json-patch+json"
+	ContentTypeMergePatch        = "application/merge-patch+json"
 	ContentTypeMsgpack           = "application/msgpack"
 	ContentTypeMultipartFormData = "multipart/form-data"
 	ContentTypeOctetStream       = "application/octet-stream"
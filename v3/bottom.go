@@ -75,6 +75,12 @@ func (b *bottomHandler) ServeHTTP(rw http.ResponseWriter, req *http.Request, nex
 	d.init(b.s, res, req, requestId, "luddite.bottomHandler.begin")
 	ctx = withHandlerDetails(ctx, d)
 
+	// Identify the caller by its verified client certificate, if any. The
+	// service may override this using SetContextCallerId.
+	if req.TLS != nil && len(req.TLS.VerifiedChains) > 0 && len(req.TLS.VerifiedChains[0]) > 0 {
+		d.callerId = req.TLS.VerifiedChains[0][0].Subject.String()
+	}
+
 	// Create a shallow copy of the request so that it references the final
 	// context
 	req = req.WithContext(ctx)
@@ -114,9 +120,11 @@ func (b *bottomHandler) ServeHTTP(rw http.ResponseWriter, req *http.Request, nex
 		if !d.skipInfoLog || (status >= 400) || (b.accessLogger.Level != log.InfoLevel) {
 
 			// Log the request
+			clientIP := RequestClientIP(req)
 			fields := log.Fields{
 				"client_addr":   req.RemoteAddr,
 				"forwarded_for": req.Header.Get(HeaderForwardedFor),
+				"client_ip":     clientIP,
 				"proto":         req.Proto,
 				"method":        req.Method,
 				"uri":           req.RequestURI,
@@ -150,6 +158,7 @@ func (b *bottomHandler) ServeHTTP(rw http.ResponseWriter, req *http.Request, nex
 				serverSpan.SetTag("http.status_code", res.Status())
 				serverSpan.SetTag("http.response_size", res.Size())
 				serverSpan.SetTag("http.request_id", requestId)
+				serverSpan.SetTag("http.client_ip", clientIP)
 				serverSpan.SetTag("api_version", d.apiVersion)
 				if sessionId != "" {
 					serverSpan.SetTag("session_id", sessionId)
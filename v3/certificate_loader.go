@@ -1,10 +1,22 @@
 package luddite
 
 import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
 	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
 	"fmt"
+	"io"
+	"math/big"
+	"net"
 	"os"
 	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
 	"sync/atomic"
 	"time"
 
@@ -12,7 +24,14 @@ import (
 )
 
 const (
-	defaultWatcherScanMinutes = 5
+	defaultWatcherScanMinutes    = 5
+	watcherDebounceDelay         = time.Second
+	defaultCertExpiryWarningDays = 30
+	certExpiryCheckInterval      = 24 * time.Hour
+	selfSignedCertValidity       = 365 * 24 * time.Hour
+
+	certFileExt = ".crt"
+	keyFileExt  = ".key"
 )
 
 type CertificateLoader interface {
@@ -21,6 +40,17 @@ type CertificateLoader interface {
 }
 
 type certLoader struct {
+	pairs       atomic.Pointer[[]*certPair]
+	lock        sync.Mutex // serializes changes to pairs
+	config      *ServiceConfig
+	certDirPath string
+	dirWatcher  Watcher
+	done        chan interface{}
+	log         *log.Logger
+}
+
+// certPair is a single certificate/key pair, reloaded when either file changes.
+type certPair struct {
 	cert         atomic.Pointer[tls.Certificate]
 	certFilePath string
 	keyFilePath  string
@@ -28,45 +58,413 @@ type certLoader struct {
 	log          *log.Logger
 }
 
+// NewCertificateLoader loads the certificate/key pairs given by the service's
+// transport config: CertFilePath/KeyFilePath, Certificates and the pairs found
+// in CertDirPath. GetCertificate selects a pair by the client's SNI server
+// name, falling back to the first pair loaded. Pairs added to CertDirPath
+// later are loaded when the directory changes.
 func NewCertificateLoader(config *ServiceConfig, logger *log.Logger) (CertificateLoader, error) {
+	paths, err := certificateFilePaths(config)
+	if err != nil {
+		return nil, err
+	}
+
 	cl := &certLoader{
-		certFilePath: config.Transport.CertFilePath,
-		keyFilePath:  config.Transport.KeyFilePath,
-		log:          logger,
+		config:      config,
+		certDirPath: config.Transport.CertDirPath,
+		done:        make(chan interface{}),
+		log:         logger,
 	}
-	if err := cl.storeCertificate(); err != nil {
-		return nil, err
+	var pairs []*certPair
+	if len(paths) == 0 && config.Transport.SelfSigned {
+		pair := &certPair{log: logger}
+		var cert *tls.Certificate
+		if cert, err = newSelfSignedCertificate(config.Addr); err != nil {
+			return nil, err
+		}
+		pair.setCertificate(cert)
+		logger.Warn("using a generated self-signed certificate")
+		pairs = append(pairs, pair)
 	}
-	if !config.Transport.CertWatcher.Disabled {
-		cl.watcher = NewWatcher(logger, cl.certFilePath, cl.keyFilePath)
-		scanMinutes := config.Transport.CertWatcher.ScanMinutes
-		if scanMinutes == 0 {
-			scanMinutes = defaultWatcherScanMinutes
+	for _, p := range paths {
+		var pair *certPair
+		if pair, err = cl.newCertPair(p); err != nil {
+			cl.pairs.Store(&pairs)
+			cl.Close()
+			return nil, err
 		}
-		cl.watcher.Watch(cl.storeCertificate, time.Duration(scanMinutes)*time.Minute)
+		pairs = append(pairs, pair)
+	}
+	if len(pairs) == 0 {
+		return nil, ErrMissingTLSConfig
 	}
+	cl.pairs.Store(&pairs)
+
+	// Watch the directory itself for added pairs: the trailing separator
+	// makes the notify watcher watch the directory's entries rather than just
+	// its parent's
+	if cl.certDirPath != "" {
+		cl.dirWatcher = newConfiguredWatcher(config, logger, cl.rescanCertDir, cl.certDirPath+string(os.PathSeparator))
+	}
+
+	warningDays := config.Transport.CertExpiryWarningDays
+	if warningDays == 0 {
+		warningDays = defaultCertExpiryWarningDays
+	}
+	cl.monitorExpiry(time.Duration(warningDays) * 24 * time.Hour)
 	return cl, nil
 }
 
-func (l *certLoader) storeCertificate() error {
-	l.log.Debugf("storing cert: '%s', key: '%s'", l.certFilePath, l.keyFilePath)
-	cert, err := tls.LoadX509KeyPair(l.certFilePath, l.keyFilePath)
+// monitorExpiry periodically logs warnings for certificates that expire within
+// the given duration, and errors for certificates that have expired.
+func (l *certLoader) monitorExpiry(warnWithin time.Duration) {
+	check := func() {
+		now := time.Now()
+		for _, pair := range *l.pairs.Load() {
+			notAfter := pair.cert.Load().Leaf.NotAfter
+			entry := l.log.WithFields(log.Fields{
+				"certificate": pair.name(),
+				"not_after":   notAfter.UTC(),
+			})
+			if now.After(notAfter) {
+				entry.Error("certificate has expired")
+			} else if notAfter.Sub(now) < warnWithin {
+				entry.Warnf("certificate expires in %d days", int(notAfter.Sub(now).Hours()/24))
+			}
+		}
+	}
+
+	check()
+	go func() {
+		ticker := time.NewTicker(certExpiryCheckInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-l.done:
+				return
+			case <-ticker.C:
+				check()
+			}
+		}
+	}()
+}
+
+// certificateFilePaths returns the certificate/key file paths given by the
+// service's transport config, beginning with the default pair.
+func certificateFilePaths(config *ServiceConfig) ([]CertificateFilePaths, error) {
+	var paths []CertificateFilePaths
+	if config.Transport.CertFilePath != "" {
+		paths = append(paths, CertificateFilePaths{
+			CertFilePath: config.Transport.CertFilePath,
+			KeyFilePath:  config.Transport.KeyFilePath,
+		})
+	}
+	paths = append(paths, config.Transport.Certificates...)
+
+	if dir := config.Transport.CertDirPath; dir != "" {
+		dirPaths, err := certDirFilePaths(dir)
+		if err != nil {
+			return nil, err
+		}
+		paths = append(paths, dirPaths...)
+	}
+	return paths, nil
+}
+
+// certDirFilePaths returns the certificate/key file paths found in a
+// directory, named "<name>.crt" and "<name>.key".
+func certDirFilePaths(dir string) ([]CertificateFilePaths, error) {
+	certFilePaths, err := filepath.Glob(filepath.Join(dir, "*"+certFileExt))
 	if err != nil {
-		return fmt.Errorf("failed to load certificate '%s': '%s'", l.certFilePath, err)
+		return nil, err
 	}
-	l.cert.Store(&cert)
+	var paths []CertificateFilePaths
+	for _, certFilePath := range certFilePaths {
+		keyFilePath := strings.TrimSuffix(certFilePath, certFileExt) + keyFileExt
+		if _, err = os.Stat(keyFilePath); err != nil {
+			return nil, fmt.Errorf("missing key for certificate '%s': '%s'", certFilePath, err)
+		}
+		paths = append(paths, CertificateFilePaths{
+			CertFilePath: certFilePath,
+			KeyFilePath:  keyFilePath,
+		})
+	}
+	return paths, nil
+}
+
+// newCertPair loads a certificate/key pair and starts watching its files.
+func (l *certLoader) newCertPair(p CertificateFilePaths) (*certPair, error) {
+	pair := &certPair{
+		certFilePath: p.CertFilePath,
+		keyFilePath:  p.KeyFilePath,
+		log:          l.log,
+	}
+	if err := pair.storeCertificate(); err != nil {
+		return nil, err
+	}
+	pair.watcher = newConfiguredWatcher(l.config, l.log, pair.reloadCertificate, pair.certFilePath, pair.keyFilePath)
+	return pair, nil
+}
+
+// rescanCertDir loads the pairs that were added to CertDirPath since it was
+// last scanned. Pairs removed from the directory stay in use until the service
+// restarts.
+func (l *certLoader) rescanCertDir() error {
+	paths, err := certDirFilePaths(l.certDirPath)
+	if err != nil {
+		return err
+	}
+
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	select {
+	case <-l.done:
+		return nil
+	default:
+	}
+	pairs := slices.Clone(*l.pairs.Load())
+	var errs []error
+	for _, p := range paths {
+		if slices.ContainsFunc(pairs, func(pair *certPair) bool { return pair.certFilePath == p.CertFilePath }) {
+			continue
+		}
+		pair, err := l.newCertPair(p)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		l.log.Infof("loaded certificate '%s'", pair.certFilePath)
+		pairs = append(pairs, pair)
+	}
+	l.pairs.Store(&pairs)
+	return errors.Join(errs...)
+}
+
+func (p *certPair) storeCertificate() error {
+	p.log.Debugf("storing cert: '%s', key: '%s'", p.certFilePath, p.keyFilePath)
+	cert, err := p.loadCertificate()
+	if err != nil {
+		return err
+	}
+	p.setCertificate(cert)
+	return nil
+}
+
+func (p *certPair) setCertificate(cert *tls.Certificate) {
+	p.cert.Store(cert)
+	tlsCertificateExpiry.WithLabelValues(p.name()).Set(float64(cert.Leaf.NotAfter.Unix()))
+}
+
+// name identifies the pair in logs and metrics.
+func (p *certPair) name() string {
+	if p.certFilePath == "" {
+		return "self-signed"
+	}
+	return p.certFilePath
+}
+
+// reloadCertificate replaces the current certificate, provided that the new
+// certificate is usable. Otherwise the current certificate remains in use.
+func (p *certPair) reloadCertificate() (err error) {
+	defer func() { observeTLSReload(tlsReloadCertificate, err) }()
+
+	p.log.Debugf("reloading cert: '%s', key: '%s'", p.certFilePath, p.keyFilePath)
+	cert, err := p.loadCertificate()
+	if err != nil {
+		return err
+	}
+	if now := time.Now(); now.After(cert.Leaf.NotAfter) {
+		return fmt.Errorf("failed to reload certificate '%s': expired at %s", p.certFilePath, cert.Leaf.NotAfter.UTC())
+	} else if now.Before(cert.Leaf.NotBefore) {
+		return fmt.Errorf("failed to reload certificate '%s': not valid before %s", p.certFilePath, cert.Leaf.NotBefore.UTC())
+	}
+	p.setCertificate(cert)
+	p.log.Infof("reloaded certificate '%s'", p.certFilePath)
 	return nil
 }
 
-func (l *certLoader) GetCertificate(_ *tls.ClientHelloInfo) (*tls.Certificate, error) {
-	return l.cert.Load(), nil
+// loadCertificate loads and parses the key pair. This also verifies that the
+// private key matches the certificate's public key.
+func (p *certPair) loadCertificate() (*tls.Certificate, error) {
+	cert, err := tls.LoadX509KeyPair(p.certFilePath, p.keyFilePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load certificate '%s': '%s'", p.certFilePath, err)
+	}
+	if cert.Leaf == nil {
+		if cert.Leaf, err = x509.ParseCertificate(cert.Certificate[0]); err != nil {
+			return nil, fmt.Errorf("failed to parse certificate '%s': '%s'", p.certFilePath, err)
+		}
+	}
+	return &cert, nil
+}
+
+// GetCertificate returns the certificate that best matches the client's SNI
+// server name: an exact DNS name match is preferred over a wildcard match. If
+// no certificate matches, or the client didn't send a server name, the default
+// certificate is returned.
+func (l *certLoader) GetCertificate(hi *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	pairs := *l.pairs.Load()
+	if len(pairs) > 1 && hi != nil && hi.ServerName != "" {
+		serverName := strings.ToLower(strings.TrimSuffix(hi.ServerName, "."))
+		var wildcard *tls.Certificate
+		for _, pair := range pairs {
+			cert := pair.cert.Load()
+			if cert.Leaf == nil {
+				continue
+			}
+			for _, name := range cert.Leaf.DNSNames {
+				if strings.ToLower(name) == serverName {
+					return cert, nil
+				}
+			}
+			if wildcard == nil && cert.Leaf.VerifyHostname(serverName) == nil {
+				wildcard = cert
+			}
+		}
+		if wildcard != nil {
+			return wildcard, nil
+		}
+	}
+	return pairs[0].cert.Load(), nil
 }
 
 func (l *certLoader) Close() {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	close(l.done)
+	if l.dirWatcher != nil {
+		l.dirWatcher.Close()
+	}
+	for _, pair := range *l.pairs.Load() {
+		if pair.watcher != nil {
+			pair.watcher.Close()
+		}
+	}
+}
+
+// newSelfSignedCertificate generates a self-signed certificate for development
+// and testing. It is valid for localhost, the local host name and the host of
+// addr.
+func newSelfSignedCertificate(addr string) (*tls.Certificate, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	serialNumber, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	template := &x509.Certificate{
+		SerialNumber:          serialNumber,
+		Subject:               pkix.Name{CommonName: "localhost", Organization: []string{"luddite self-signed"}},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.Add(selfSignedCertValidity),
+		KeyUsage:              x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		DNSNames:              []string{"localhost"},
+		IPAddresses:           []net.IP{net.IPv4(127, 0, 0, 1), net.IPv6loopback},
+	}
+	if hostname, hostErr := os.Hostname(); hostErr == nil && hostname != "localhost" {
+		template.DNSNames = append(template.DNSNames, hostname)
+	}
+	if host, _, splitErr := net.SplitHostPort(addr); splitErr == nil && host != "" {
+		if ip := net.ParseIP(host); ip != nil {
+			template.IPAddresses = append(template.IPAddresses, ip)
+		} else if host != "localhost" {
+			template.DNSNames = append(template.DNSNames, host)
+		}
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return nil, err
+	}
+	leaf, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, err
+	}
+	return &tls.Certificate{
+		Certificate: [][]byte{der},
+		PrivateKey:  key,
+		Leaf:        leaf,
+	}, nil
+}
+
+// ClientCALoader provides the pool of CA certificates used to verify client
+// certificates. The pool is reloaded when the underlying file changes.
+type ClientCALoader interface {
+	ClientCAs() *x509.CertPool
+	Close()
+}
+
+type clientCALoader struct {
+	pool             atomic.Pointer[x509.CertPool]
+	clientCAFilePath string
+	watcher          Watcher
+	log              *log.Logger
+}
+
+func NewClientCALoader(config *ServiceConfig, logger *log.Logger) (ClientCALoader, error) {
+	cl := &clientCALoader{
+		clientCAFilePath: config.Transport.ClientCAFilePath,
+		log:              logger,
+	}
+	if err := cl.storeClientCAs(); err != nil {
+		return nil, err
+	}
+	cl.watcher = newConfiguredWatcher(config, logger, cl.reloadClientCAs, cl.clientCAFilePath)
+	return cl, nil
+}
+
+func (l *clientCALoader) storeClientCAs() error {
+	l.log.Debugf("storing client CAs: '%s'", l.clientCAFilePath)
+	pem, err := os.ReadFile(l.clientCAFilePath)
+	if err != nil {
+		return fmt.Errorf("failed to load client CAs '%s': '%s'", l.clientCAFilePath, err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return fmt.Errorf("failed to load client CAs '%s': no certificates found", l.clientCAFilePath)
+	}
+	l.pool.Store(pool)
+	return nil
+}
+
+func (l *clientCALoader) reloadClientCAs() (err error) {
+	defer func() { observeTLSReload(tlsReloadClientCA, err) }()
+	if err = l.storeClientCAs(); err == nil {
+		l.log.Infof("reloaded client CAs '%s'", l.clientCAFilePath)
+	}
+	return
+}
+
+func (l *clientCALoader) ClientCAs() *x509.CertPool {
+	return l.pool.Load()
+}
+
+func (l *clientCALoader) Close() {
 	if l.watcher != nil {
 		l.watcher.Close()
 	}
-	return
+}
+
+// newConfiguredWatcher starts watching paths according to the service's cert
+// watcher config, invoking load on changes. It returns nil when watching is
+// disabled.
+func newConfiguredWatcher(config *ServiceConfig, logger *log.Logger, load func() error, paths ...string) Watcher {
+	if config.Transport.CertWatcher.Disabled {
+		return nil
+	}
+	w := NewNotifyWatcher(logger, paths...)
+	scanMinutes := config.Transport.CertWatcher.ScanMinutes
+	if scanMinutes == 0 {
+		scanMinutes = defaultWatcherScanMinutes
+	}
+	w.Watch(load, time.Duration(scanMinutes)*time.Minute)
+	return w
 }
 
 type Watcher interface {
@@ -76,10 +474,13 @@ type Watcher interface {
 
 type watcher struct {
 	watchPaths WatchPaths
+	events     <-chan struct{}
+	closer     io.Closer
 	done       chan interface{}
 	log        *log.Logger
 }
 
+// NewWatcher creates a Watcher that polls paths for changes.
 func NewWatcher(logger *log.Logger, paths ...string) Watcher {
 	return &watcher{
 		watchPaths: NewWatchPaths(logger, paths...),
@@ -88,29 +489,62 @@ func NewWatcher(logger *log.Logger, paths ...string) Watcher {
 	}
 }
 
+// NewNotifyWatcher creates a Watcher that is notified of changes to paths by
+// the operating system (currently inotify on Linux). The directories
+// containing paths are watched so that files replaced by rename or symlink
+// swap (e.g. Kubernetes secret volumes) are detected. Bursts of changes are
+// debounced before the load callback is invoked, and paths are still polled
+// as a fallback. If notifications are not available, the Watcher only polls.
+func NewNotifyWatcher(logger *log.Logger, paths ...string) Watcher {
+	w := NewWatcher(logger, paths...).(*watcher)
+	events, closer, err := newNotifyEvents(paths)
+	if err != nil {
+		logger.WithError(err).Warn("file notifications are not available, polling for changes")
+		return w
+	}
+	w.events = events
+	w.closer = closer
+	return w
+}
+
 func (w *watcher) Close() {
 	close(w.done)
+	if w.closer != nil {
+		_ = w.closer.Close()
+	}
 }
 
 func (w *watcher) Watch(loadCertCallback func() error, frequency time.Duration) {
 	go func() {
 		ticker := time.NewTicker(frequency)
 		defer ticker.Stop()
+		var debounce <-chan time.Time
 		for {
 			select {
 			case <-w.done:
 				return
+			case <-w.events:
+				// Wait for changes to settle, e.g. while a key pair
+				// is still being written
+				debounce = time.After(watcherDebounceDelay)
+			case <-debounce:
+				debounce = nil
+				w.update(loadCertCallback)
 			case <-ticker.C:
-				if w.watchPaths.Update() {
-					if err := loadCertCallback(); err != nil {
-						w.log.WithError(err).Error("error reloading certificate")
-					}
-				}
+				w.update(loadCertCallback)
 			}
 		}
 	}()
 }
 
+func (w *watcher) update(loadCertCallback func() error) {
+	if w.watchPaths.Update() {
+		if err := loadCertCallback(); err != nil {
+			w.log.WithError(err).Error("error reloading certificate")
+		}
+	}
+}
+
 type WatchPaths []WatchPath
 
 func NewWatchPaths(logger *log.Logger, paths ...string) WatchPaths {
@@ -123,7 +557,9 @@ func NewWatchPaths(logger *log.Logger, paths ...string) WatchPaths {
 
 func (wps WatchPaths) Update() (modified bool) {
 	for _, wp := range wps {
-		modified = modified || wp.Update()
+		// Update every path, so that a change to several paths is only
+		// reported once
+		modified = wp.Update() || modified
 	}
 	return
 }
@@ -133,9 +569,9 @@ type WatchPath interface {
 }
 
 type watchPath struct {
-	path    string
-	modTime time.Time
-	log     *log.Logger
+	path     string
+	fileInfo os.FileInfo
+	log      *log.Logger
 }
 
 func NewWatchPath(p string, logger *log.Logger) WatchPath {
@@ -147,28 +583,32 @@ func NewWatchPath(p string, logger *log.Logger) WatchPath {
 	return wp
 }
 
+// Update reports whether the file has changed since the last call. A file is
+// considered changed if it was replaced (e.g. by rename) or its size or mod
+// time changed, since mod times alone are too coarse to detect a quick
+// succession of writes.
 func (wp *watchPath) Update() (modified bool) {
-	if latestModTime := wp.latestModTime(); !latestModTime.IsZero() {
-		if modified = wp.modTime.IsZero() || !wp.modTime.Equal(latestModTime); modified {
-			wp.modTime = latestModTime
-			wp.log.Debugf("mod time stored for path '%s'", wp.path)
+	if fi := wp.latestFileInfo(); fi != nil {
+		prev := wp.fileInfo
+		if modified = prev == nil || !os.SameFile(prev, fi) || prev.Size() != fi.Size() || !prev.ModTime().Equal(fi.ModTime()); modified {
+			wp.fileInfo = fi
+			wp.log.Debugf("file info stored for path '%s'", wp.path)
 		}
 	}
 	return
 }
 
-func (wp *watchPath) latestModTime() (lmt time.Time) {
+func (wp *watchPath) latestFileInfo() os.FileInfo {
 	f, err := filepath.EvalSymlinks(wp.path)
 	if err != nil {
 		wp.log.WithError(err).Errorf("failed to eval file path '%s'", wp.path)
-		return
+		return nil
 	}
 	fi, err := os.Stat(f)
 	if err != nil {
 		wp.log.WithError(err).Errorf("failed to get file info '%s'", f)
-		return
+		return nil
 	}
-	lmt = fi.ModTime().UTC()
-	wp.log.Debugf("got file info '%s': '%s'", f, lmt)
-	return
+	wp.log.Debugf("got file info '%s': '%s'", f, fi.ModTime().UTC())
+	return fi
 }
@@ -0,0 +1,156 @@
+package luddite
+
+import (
+	"crypto/tls"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestLogger() *log.Logger {
+	logger := log.New()
+	logger.Out = io.Discard
+	return logger
+}
+
+func TestCertificateLoaderSNI(t *testing.T) {
+	dir := t.TempDir()
+	defaultCert := newTestCert(t, dir, "default.example.com", false, nil)
+	apiCert := newTestCert(t, dir, "api.example.com", false, nil)
+	wildcardCert := newTestCert(t, dir, "*.example.com", false, nil)
+
+	// Certificates in CertDirPath must follow the "<name>.crt" and
+	// "<name>.key" naming convention
+	certDir := filepath.Join(dir, "certs")
+	require.NoError(t, os.Mkdir(certDir, 0700))
+	require.NoError(t, os.Rename(wildcardCert.certPath, filepath.Join(certDir, "wildcard.crt")))
+	require.NoError(t, os.Rename(wildcardCert.keyPath, filepath.Join(certDir, "wildcard.key")))
+
+	config := new(ServiceConfig)
+	config.Transport.TLS = true
+	config.Transport.CertFilePath = defaultCert.certPath
+	config.Transport.KeyFilePath = defaultCert.keyPath
+	config.Transport.Certificates = []CertificateFilePaths{{
+		CertFilePath: apiCert.certPath,
+		KeyFilePath:  apiCert.keyPath,
+	}}
+	config.Transport.CertDirPath = certDir
+	config.Transport.CertWatcher.Disabled = true
+
+	cl, err := NewCertificateLoader(config, newTestLogger())
+	require.NoError(t, err)
+	defer cl.Close()
+
+	for serverName, expected := range map[string]*testCert{
+		"":                    defaultCert,
+		"api.example.com":     apiCert,
+		"API.example.com.":    apiCert,
+		"www.example.com":     wildcardCert,
+		"default.example.com": defaultCert,
+		"www.example.org":     defaultCert,
+	} {
+		cert, err := cl.GetCertificate(&tls.ClientHelloInfo{ServerName: serverName})
+		require.NoError(t, err)
+		require.Equal(t, expected.cert.Raw, cert.Leaf.Raw, "server name %q", serverName)
+	}
+}
+
+func TestCertificateLoaderCertDir(t *testing.T) {
+	certDir := t.TempDir()
+	defaultCert := newTestCert(t, t.TempDir(), "default.example.com", false, nil)
+	require.NoError(t, os.Rename(defaultCert.certPath, filepath.Join(certDir, "default.crt")))
+	require.NoError(t, os.Rename(defaultCert.keyPath, filepath.Join(certDir, "default.key")))
+
+	config := new(ServiceConfig)
+	config.Transport.TLS = true
+	config.Transport.CertDirPath = certDir
+	config.Transport.CertWatcher.ScanMinutes = 60
+
+	cl, err := NewCertificateLoader(config, newTestLogger())
+	require.NoError(t, err)
+	defer cl.Close()
+
+	// Pairs added to the directory are loaded without a restart
+	apiCert := newTestCert(t, t.TempDir(), "api.example.com", false, nil)
+	require.NoError(t, os.Rename(apiCert.keyPath, filepath.Join(certDir, "api.key")))
+	require.NoError(t, os.Rename(apiCert.certPath, filepath.Join(certDir, "api.crt")))
+	require.Eventually(t, func() bool {
+		cert, _ := cl.GetCertificate(&tls.ClientHelloInfo{ServerName: "api.example.com"})
+		return string(cert.Leaf.Raw) == string(apiCert.cert.Raw)
+	}, 10*time.Second, 50*time.Millisecond)
+
+	cert, err := cl.GetCertificate(&tls.ClientHelloInfo{})
+	require.NoError(t, err)
+	require.Equal(t, defaultCert.cert.Raw, cert.Leaf.Raw)
+}
+
+func TestCertificateLoaderReload(t *testing.T) {
+	dir := t.TempDir()
+	current := newTestCert(t, dir, "localhost", false, nil)
+
+	config := new(ServiceConfig)
+	config.Transport.TLS = true
+	config.Transport.CertFilePath = current.certPath
+	config.Transport.KeyFilePath = current.keyPath
+	config.Transport.CertWatcher.ScanMinutes = 60
+
+	cl, err := NewCertificateLoader(config, newTestLogger())
+	require.NoError(t, err)
+	defer cl.Close()
+
+	replace := func(tc *testCert) {
+		require.NoError(t, os.Rename(tc.certPath, current.certPath))
+		require.NoError(t, os.Rename(tc.keyPath, current.keyPath))
+	}
+	loaded := func() []byte {
+		cert, _ := cl.GetCertificate(&tls.ClientHelloInfo{})
+		return cert.Leaf.Raw
+	}
+
+	// Changed files are picked up without waiting for the next scan
+	next := newTestCert(t, t.TempDir(), "localhost", false, nil)
+	replace(next)
+	require.Eventually(t, func() bool {
+		return string(loaded()) == string(next.cert.Raw)
+	}, 10*time.Second, 50*time.Millisecond)
+
+	// Expired certificates are not swapped in
+	pair := (*cl.(*certLoader).pairs.Load())[0]
+	expired := newTestCertValidUntil(t, t.TempDir(), "localhost", false, nil, time.Now().Add(-time.Minute))
+	replace(expired)
+	require.Error(t, pair.reloadCertificate())
+	require.Equal(t, next.cert.Raw, loaded())
+
+	// Neither are certificates that don't match their key
+	mismatched := newTestCert(t, t.TempDir(), "localhost", false, nil)
+	require.NoError(t, os.Rename(mismatched.certPath, current.certPath))
+	require.Error(t, pair.reloadCertificate())
+	require.Equal(t, next.cert.Raw, loaded())
+}
+
+func TestCertificateLoaderSelfSigned(t *testing.T) {
+	config := new(ServiceConfig)
+	config.Addr = "192.0.2.1:8443"
+	config.Version.Min = 1
+	config.Version.Max = 1
+	config.Transport.TLS = true
+	require.ErrorIs(t, config.Validate(), ErrMissingTLSConfig)
+	config.Transport.SelfSigned = true
+	require.NoError(t, config.Validate())
+
+	cl, err := NewCertificateLoader(config, newTestLogger())
+	require.NoError(t, err)
+	defer cl.Close()
+
+	cert, err := cl.GetCertificate(&tls.ClientHelloInfo{ServerName: "localhost"})
+	require.NoError(t, err)
+	require.NoError(t, cert.Leaf.VerifyHostname("localhost"))
+	require.NoError(t, cert.Leaf.VerifyHostname("192.0.2.1"))
+	require.Equal(t, float64(cert.Leaf.NotAfter.Unix()), testutil.ToFloat64(tlsCertificateExpiry.WithLabelValues("self-signed")))
+}
@@ -0,0 +1,87 @@
+package luddite
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// Component is a dependency whose lifecycle is tied to a service, e.g. a
+// database pool or message bus client. Components are started in the order
+// they were added, before the service's listeners open, and stopped in reverse
+// order once in-flight requests have drained.
+type Component interface {
+	// Start starts the component. If it returns an error, the service does
+	// not run and components that were already started are stopped.
+	Start(ctx context.Context) error
+
+	// Stop stops the component. ctx is canceled when the service's
+	// shutdown timeout elapses.
+	Stop(ctx context.Context) error
+}
+
+type hookComponent struct {
+	start func(ctx context.Context) error
+	stop  func(ctx context.Context) error
+}
+
+func (h *hookComponent) Start(ctx context.Context) error {
+	if h.start == nil {
+		return nil
+	}
+	return h.start(ctx)
+}
+
+func (h *hookComponent) Stop(ctx context.Context) error {
+	if h.stop == nil {
+		return nil
+	}
+	return h.stop(ctx)
+}
+
+// AddComponent adds a component to the service. All components must be added
+// before Run is called.
+func (s *Service) AddComponent(c Component) {
+	s.components = append(s.components, c)
+}
+
+// OnStart registers a function to call when the service starts. It is
+// equivalent to adding a component with only a Start method.
+func (s *Service) OnStart(f func(ctx context.Context) error) {
+	s.AddComponent(&hookComponent{start: f})
+}
+
+// OnStop registers a function to call when the service stops. It is
+// equivalent to adding a component with only a Stop method.
+func (s *Service) OnStop(f func(ctx context.Context) error) {
+	s.AddComponent(&hookComponent{stop: f})
+}
+
+// startComponents starts all components in order. If any component fails to
+// start, those already started are stopped in reverse order.
+func (s *Service) startComponents(ctx context.Context) error {
+	for i, c := range s.components {
+		if err := c.Start(ctx); err != nil {
+			s.defaultLogger.WithError(err).Error("component failed to start")
+			return errors.Join(err, s.stopComponents(s.components[:i]))
+		}
+	}
+	return nil
+}
+
+// stopComponents stops the given components in reverse order, waiting up to
+// the configured shutdown timeout.
+func (s *Service) stopComponents(components []Component) error {
+	timeout := time.Duration(s.config.Shutdown.TimeoutSeconds) * time.Second
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	var errs []error
+	for i := len(components) - 1; i >= 0; i-- {
+		if err := components[i].Stop(ctx); err != nil {
+			s.defaultLogger.WithError(err).Warn("component failed to stop")
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,73 @@
+package luddite
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+type testComponent struct {
+	name     string
+	events   *[]string
+	startErr error
+}
+
+func (c *testComponent) Start(context.Context) error {
+	*c.events = append(*c.events, "start "+c.name)
+	return c.startErr
+}
+
+func (c *testComponent) Stop(context.Context) error {
+	*c.events = append(*c.events, "stop "+c.name)
+	return nil
+}
+
+func TestServiceComponents(t *testing.T) {
+	var events []string
+	s := newTestService(t)
+	s.AddComponent(&testComponent{name: "db", events: &events})
+	s.OnStart(func(context.Context) error {
+		events = append(events, "start hook")
+		return nil
+	})
+	s.OnStop(func(context.Context) error {
+		events = append(events, "stop hook")
+		return nil
+	})
+	s.AddComponent(&testComponent{name: "bus", events: &events})
+	s.OnStart(func(context.Context) error {
+		require.Len(t, s.listeners, 0, "components must start before listeners open")
+		return nil
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	require.NoError(t, s.RunContext(ctx))
+	require.Equal(t, []string{
+		"start db",
+		"start hook",
+		"start bus",
+		"stop bus",
+		"stop hook",
+		"stop db",
+	}, events)
+}
+
+func TestServiceComponentStartError(t *testing.T) {
+	var events []string
+	startErr := errors.New("connection refused")
+	s := newTestService(t)
+	s.AddComponent(&testComponent{name: "db", events: &events})
+	s.AddComponent(&testComponent{name: "bus", events: &events, startErr: startErr})
+	s.AddComponent(&testComponent{name: "cache", events: &events})
+
+	require.ErrorIs(t, s.RunContext(context.Background()), startErr)
+	require.Equal(t, []string{
+		"start db",
+		"start bus",
+		"stop db",
+	}, events)
+	require.Len(t, s.listeners, 0)
+}
@@ -0,0 +1,146 @@
+package luddite
+
+import (
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Versioned is implemented by resource values that carry version information.
+// When a resource route returns a Versioned value, the ETag and Last-Modified
+// response headers are set automatically, GET requests with a matching
+// If-None-Match (or If-Modified-Since) header are answered with 304 Not
+// Modified, and PUT, PATCH and DELETE requests whose If-Match (or
+// If-Unmodified-Since) header doesn't match the current value are rejected
+// with 412 Precondition Failed.
+type Versioned interface {
+	// ETag returns the value's entity tag, e.g. `"v42"` or `W/"v42"`, or an
+	// empty string if the value has none. Unquoted tags are quoted.
+	ETag() string
+
+	// LastModified returns the time at which the value was last modified, or
+	// the zero time if unknown.
+	LastModified() time.Time
+}
+
+// writeVersionedResponse writes a resource route's response, adding version
+// headers for Versioned values and answering conditional GET requests with 304
+// Not Modified when the client's copy is current.
+func writeVersionedResponse(rw http.ResponseWriter, req *http.Request, status int, v interface{}) {
+	if x, ok := v.(Versioned); ok && status/100 == 2 {
+		etag := formatETag(x.ETag())
+		if etag != "" {
+			rw.Header().Set(HeaderETag, etag)
+		}
+		lastModified := x.LastModified()
+		if !lastModified.IsZero() {
+			rw.Header().Set(HeaderLastModified, lastModified.UTC().Format(http.TimeFormat))
+		}
+		if status == http.StatusOK && (req.Method == http.MethodGet || req.Method == http.MethodHead) && notModified(req, etag, lastModified) {
+			rw.WriteHeader(http.StatusNotModified)
+			return
+		}
+	}
+	_ = WriteResponse(rw, status, v)
+}
+
+// checkPreconditions evaluates a request's If-Match and If-Unmodified-Since
+// headers against the current value of a resource, as returned by get, and
+// writes a 412 Precondition Failed response if they aren't met. Requests
+// without these headers, or for resources whose current value can't be
+// retrieved (get is nil), are always allowed to proceed.
+func checkPreconditions(rw http.ResponseWriter, req *http.Request, get func() (int, interface{})) bool {
+	ifMatch := req.Header.Get(HeaderIfMatch)
+	ifUnmodifiedSince := req.Header.Get(HeaderIfUnmodifiedSince)
+	if get == nil || (ifMatch == "" && ifUnmodifiedSince == "") {
+		return true
+	}
+
+	status, v := get()
+	exists := status == http.StatusOK
+	var (
+		etag         string
+		lastModified time.Time
+	)
+	if x, ok := v.(Versioned); ok && exists {
+		etag = formatETag(x.ETag())
+		lastModified = x.LastModified()
+	}
+
+	var header string
+	if ifMatch != "" {
+		if !exists || !matchETag(ifMatch, etag, false) {
+			header = HeaderIfMatch
+		}
+	} else if t, err := http.ParseTime(ifUnmodifiedSince); err == nil {
+		if !exists || lastModified.IsZero() || lastModified.Truncate(time.Second).After(t) {
+			header = HeaderIfUnmodifiedSince
+		}
+	}
+	if header == "" {
+		return true
+	}
+	_ = WriteResponse(rw, http.StatusPreconditionFailed, NewError(nil, EcodePreconditionFailed, header))
+	return false
+}
+
+// collectionGetFunc returns a function that gets the current value of a
+// collection element, if the resource is also a CollectionGetter.
+func collectionGetFunc(r interface{}, req *http.Request, id string) func() (int, interface{}) {
+	if g, ok := r.(CollectionGetter); ok {
+		return func() (int, interface{}) { return g.Get(req, id) }
+	}
+	return nil
+}
+
+// singletonGetFunc returns a function that gets the current value of a
+// singleton, if the resource is also a SingletonGetter.
+func singletonGetFunc(r interface{}, req *http.Request) func() (int, interface{}) {
+	if g, ok := r.(SingletonGetter); ok {
+		return func() (int, interface{}) { return g.Get(req) }
+	}
+	return nil
+}
+
+// notModified evaluates a GET request's If-None-Match and If-Modified-Since
+// headers, returning true if the client's copy is current.
+func notModified(req *http.Request, etag string, lastModified time.Time) bool {
+	if ifNoneMatch := req.Header.Get(HeaderIfNoneMatch); ifNoneMatch != "" {
+		return matchETag(ifNoneMatch, etag, true)
+	}
+	if lastModified.IsZero() {
+		return false
+	}
+	t, err := http.ParseTime(req.Header.Get(HeaderIfModifiedSince))
+	return err == nil && !lastModified.Truncate(time.Second).After(t)
+}
+
+// matchETag reports whether an If-Match or If-None-Match header value matches
+// an entity tag, using the weak or strong comparison function of RFC 9110.
+func matchETag(header, etag string, weak bool) bool {
+	for _, tag := range splitQuoted(header, ',') {
+		tag = strings.TrimSpace(tag)
+		if tag == "*" {
+			return true
+		}
+		if etag == "" {
+			continue
+		}
+		if weak {
+			if strings.TrimPrefix(tag, "W/") == strings.TrimPrefix(etag, "W/") {
+				return true
+			}
+		} else if tag == etag && !strings.HasPrefix(tag, "W/") {
+			return true
+		}
+	}
+	return false
+}
+
+// formatETag quotes an entity tag, unless it is already quoted.
+func formatETag(etag string) string {
+	if etag == "" || strings.HasPrefix(etag, `"`) || strings.HasPrefix(etag, `W/"`) {
+		return etag
+	}
+	return `"` + etag + `"`
+}
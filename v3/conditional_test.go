@@ -0,0 +1,79 @@
+package luddite
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestConditionalRequests(t *testing.T) {
+	s := newTestService(t)
+	modified := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	r := &testThingResource{value: &testThing{Id: "42", Version: 1, Modified: modified}}
+	require.NoError(t, s.AddResource(1, "/things", r))
+
+	do := func(method, header, value, body string) *httptest.ResponseRecorder {
+		h := make(http.Header)
+		if header != "" {
+			h.Set(header, value)
+		}
+		return serveTestRequest(t, s, 1, method, "/things/42", h, body)
+	}
+
+	rw := do("GET", "", "", "")
+	require.Equal(t, http.StatusOK, rw.Code)
+	require.Equal(t, `"v1"`, rw.Header().Get(HeaderETag))
+	require.Equal(t, "Wed, 01 May 2024 12:00:00 GMT", rw.Header().Get(HeaderLastModified))
+
+	rw = do("GET", HeaderIfNoneMatch, `"v0", W/"v1"`, "")
+	require.Equal(t, http.StatusNotModified, rw.Code)
+	require.Empty(t, rw.Body.Bytes())
+	require.Equal(t, `"v1"`, rw.Header().Get(HeaderETag))
+	rw = do("GET", HeaderIfNoneMatch, `"v0"`, "")
+	require.Equal(t, http.StatusOK, rw.Code)
+	rw = do("GET", HeaderIfModifiedSince, "Wed, 01 May 2024 12:00:00 GMT", "")
+	require.Equal(t, http.StatusNotModified, rw.Code)
+	rw = do("GET", HeaderIfModifiedSince, "Wed, 01 May 2024 11:59:59 GMT", "")
+	require.Equal(t, http.StatusOK, rw.Code)
+
+	rw = do("PUT", HeaderIfMatch, `"v0"`, `{"id":"42"}`)
+	require.Equal(t, http.StatusPreconditionFailed, rw.Code)
+	require.Contains(t, rw.Body.String(), EcodePreconditionFailed)
+	rw = do("PUT", HeaderIfMatch, `W/"v1"`, `{"id":"42"}`)
+	require.Equal(t, http.StatusPreconditionFailed, rw.Code)
+	require.Equal(t, 0, r.updates)
+
+	rw = do("PUT", HeaderIfMatch, `"v1"`, `{"id":"42"}`)
+	require.Equal(t, http.StatusOK, rw.Code)
+	require.Equal(t, `"v2"`, rw.Header().Get(HeaderETag))
+	require.Equal(t, 1, r.updates)
+
+	rw = do("PUT", HeaderIfUnmodifiedSince, "Wed, 01 May 2024 12:00:00 GMT", `{"id":"42"}`)
+	require.Equal(t, http.StatusPreconditionFailed, rw.Code)
+	rw = do("PUT", "", "", `{"id":"42"}`)
+	require.Equal(t, http.StatusOK, rw.Code)
+	require.Equal(t, 2, r.updates)
+
+	rw = do("DELETE", HeaderIfMatch, `"v2"`, "")
+	require.Equal(t, http.StatusPreconditionFailed, rw.Code)
+	rw = do("DELETE", HeaderIfMatch, `"v3"`, "")
+	require.Equal(t, http.StatusNoContent, rw.Code)
+	require.Equal(t, 1, r.deletes)
+	rw = do("DELETE", HeaderIfMatch, "*", "")
+	require.Equal(t, http.StatusPreconditionFailed, rw.Code)
+}
+
+func TestMatchETag(t *testing.T) {
+	require.True(t, matchETag(`"a", "b"`, `"b"`, false))
+	require.True(t, matchETag(`*`, `"b"`, false))
+	require.False(t, matchETag(`W/"b"`, `"b"`, false))
+	require.False(t, matchETag(`"b"`, `W/"b"`, false))
+	require.True(t, matchETag(`W/"b"`, `"b"`, true))
+	require.True(t, matchETag(`"a,b"`, `"a,b"`, false))
+	require.False(t, matchETag(`"a"`, "", true))
+	require.Equal(t, `"a"`, formatETag("a"))
+	require.Equal(t, `W/"a"`, formatETag(`W/"a"`))
+}
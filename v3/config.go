@@ -1,7 +1,9 @@
 package luddite
 
 import (
+	"crypto/tls"
 	"errors"
+	"fmt"
 	"io"
 	"os"
 
@@ -9,8 +11,15 @@ import (
 )
 
 const (
-	defaultMetricsURIPath  = "/metrics"
-	defaultProfilerURIPath = "/debug/pprof"
+	defaultMetricsURIPath            = "/metrics"
+	defaultProfilerURIPath           = "/debug/pprof"
+	defaultShutdownTimeoutSeconds    = 30
+	defaultReadHeaderTimeoutSeconds  = 10
+	defaultIdleTimeoutSeconds        = 120
+	defaultKeepAlivePeriodSeconds    = 180
+	defaultProxyHeaderTimeoutSeconds = 5
+	maxConnectionsPolicyBlock        = "block"
+	maxConnectionsPolicyReject       = "reject"
 )
 
 var (
@@ -24,19 +33,66 @@ var (
 	ErrMismatchedApiVersions = errors.New("service's maximum API version must be greater than or equal to the minimum API version")
 
 	// ErrMissingTLSConfig occurs when TLS is enabled without required file paths
-	ErrMissingTLSConfig = errors.New("must set both CertFilePath and KeyFilePath to enable TLS transport")
+	ErrMissingTLSConfig = errors.New("must set both CertFilePath and KeyFilePath (or Certificates, CertDirPath or SelfSigned) to enable TLS transport")
+
+	// ErrPlaintextAddrWithoutTLS occurs when a plaintext address is set without enabling TLS
+	ErrPlaintextAddrWithoutTLS = errors.New("PlaintextAddr requires TLS transport; use Addr to serve plain HTTP")
+
+	// ErrInvalidDefaultPageSize occurs when the default page size is greater than the maximum page size
+	ErrInvalidDefaultPageSize = errors.New("Paging DefaultPageSize must be less than or equal to MaxPageSize")
+
+	// ErrInvalidRedirectPort occurs when the HTTPS redirect port is outside the range of TCP ports
+	ErrInvalidRedirectPort = errors.New("RedirectPort must be between 0 and 65535")
+
+	// ErrInvalidHTTP2MaxReadFrameSize occurs when the HTTP/2 max read frame size is outside the range allowed by RFC 9113
+	ErrInvalidHTTP2MaxReadFrameSize = errors.New("HTTP/2 MaxReadFrameSize must be between 16384 and 16777215")
+
+	// ErrMissingProxyProtocolTrustedCIDRs occurs when PROXY protocol support is enabled without trusted proxies
+	ErrMissingProxyProtocolTrustedCIDRs = errors.New("must set TrustedCIDRs to enable PROXY protocol support")
+
+	// ErrMissingAdminAddr occurs when the admin listener is enabled without an address
+	ErrMissingAdminAddr = errors.New("must set Addr to enable the admin listener")
+
+	// ErrAdminTLSWithoutTLS occurs when admin TLS is enabled without enabling TLS transport
+	ErrAdminTLSWithoutTLS = errors.New("admin TLS requires TLS transport")
+
+	// ErrMissingClientCAConfig occurs when client certificate verification is enabled without a CA bundle
+	ErrMissingClientCAConfig = errors.New("must set ClientCAFilePath to verify client certificates")
 
 	defaultCORSAllowedMethods = []string{"GET", "POST", "PUT", "DELETE"}
 )
 
 // ServiceConfig holds a service's config values.
 type ServiceConfig struct {
-	// Addr is the address:port pair that the HTTP server listens on.
+	// Addr is the address:port pair that the HTTP server listens on. It may
+	// also be of the form "unix:/path/to/socket" to listen on a Unix domain
+	// socket, or "fd:N" to listen on an inherited file descriptor.
 	Addr string
 
 	// Prefix is a prefix to add to every path
 	Prefix string
 
+	// TrustedProxies sets the networks (or single IP addresses) of reverse proxies whose Forwarded and X-Forwarded-* headers are honored when determining a request's client address, scheme and host.
+	TrustedProxies []string `yaml:"trusted_proxies"`
+
+	Admin struct {
+		// Enabled, when true, serves metrics, profiler and other operational endpoints on a separate admin listener instead of the public one.
+		Enabled bool
+
+		// Addr sets the admin listener address. It accepts the same forms as ServiceConfig.Addr.
+		Addr string
+
+		// TLS, when true, causes the admin listener to use HTTPS with the certificates and TLS policy from Transport. Client certificates are not requested.
+		TLS bool `yaml:"tls"`
+
+		// Username and Password, when Username is set, require HTTP basic authentication on the admin listener.
+		Username string
+		Password string
+
+		// Schema, when true, serves the schema routes on the admin listener instead of the public one.
+		Schema bool
+	}
+
 	CORS struct {
 		// Enabled, when true, enables CORS.
 		Enabled bool
@@ -65,6 +121,20 @@ type ServiceConfig struct {
 		Stacks bool
 	}
 
+	Health struct {
+		// Enabled, when true, enables the service's liveness and readiness endpoints.
+		Enabled bool
+
+		// LivenessURIPath sets the liveness path. Defaults to "/healthz".
+		LivenessURIPath string `yaml:"liveness_uri_path"`
+
+		// ReadinessURIPath sets the readiness path. Defaults to "/readyz".
+		ReadinessURIPath string `yaml:"readiness_uri_path"`
+
+		// CheckTimeoutSeconds sets the timeout for health checks added without one. Defaults to 5.
+		CheckTimeoutSeconds int `yaml:"check_timeout_seconds"`
+	}
+
 	Log struct {
 		// ServiceLogPath sets the file path for the service log (written as JSON). If unset, defaults to stdout (written as text).
 		ServiceLogPath string `yaml:"service_log_path"`
@@ -84,6 +154,20 @@ type ServiceConfig struct {
 		URIPath string `yaml:"uri_path"`
 	}
 
+	Paging struct {
+		// DefaultPageSize sets the page size for paginated lists when the client doesn't send an X-Spirent-Page-Size header. Defaults to 100.
+		DefaultPageSize int `yaml:"default_page_size"`
+
+		// MaxPageSize sets the largest page size a client may request. Defaults to 1000.
+		MaxPageSize int `yaml:"max_page_size"`
+
+		// MaxUnpagedItems sets the largest number of elements returned to a client that sends an X-Spirent-Inhibit-Paging header. Larger collections are rejected with 400 Bad Request. Defaults to 10000.
+		MaxUnpagedItems int `yaml:"max_unpaged_items"`
+
+		// CursorTTLSeconds sets how long cursors returned by Service.CursorCodec remain valid. Defaults to no expiry.
+		CursorTTLSeconds int `yaml:"cursor_ttl_seconds"`
+	}
+
 	Profiler struct {
 		// Enabled, when true, enables the service's profiling endpoints.
 		Enabled bool
@@ -92,6 +176,17 @@ type ServiceConfig struct {
 		URIPath string `yaml:"uri_path"`
 	}
 
+	ProxyProtocol struct {
+		// Enabled, when true, causes public TCP listeners to accept PROXY protocol (version 1 and 2) headers from trusted proxies, so that the client address they carry is used as the request's remote address.
+		Enabled bool
+
+		// TrustedCIDRs sets the networks (or single IP addresses) of proxies that are trusted to send PROXY protocol headers. Headers from other peers are not parsed.
+		TrustedCIDRs []string `yaml:"trusted_cidrs"`
+
+		// HeaderTimeoutSeconds sets how long to wait for a trusted proxy to send the PROXY protocol header. Defaults to 5.
+		HeaderTimeoutSeconds int `yaml:"header_timeout_seconds"`
+	} `yaml:"proxy_protocol"`
+
 	Schema struct {
 		// Enabled, when true, self-serve the service's own schema.
 		Enabled bool
@@ -109,6 +204,45 @@ type ServiceConfig struct {
 		RootRedirect bool `yaml:"root_redirect"`
 	}
 
+	Server struct {
+		// ReadHeaderTimeoutSeconds sets how long the server waits to read request headers. Defaults to 10.
+		ReadHeaderTimeoutSeconds int `yaml:"read_header_timeout_seconds"`
+
+		// ReadTimeoutSeconds sets how long the server waits to read an entire request, including the body. Defaults to no timeout.
+		ReadTimeoutSeconds int `yaml:"read_timeout_seconds"`
+
+		// WriteTimeoutSeconds sets how long the server waits to write a response, measured from the end of the request headers. Defaults to no timeout.
+		WriteTimeoutSeconds int `yaml:"write_timeout_seconds"`
+
+		// IdleTimeoutSeconds sets how long the server keeps an idle keep-alive connection open. Defaults to 120.
+		IdleTimeoutSeconds int `yaml:"idle_timeout_seconds"`
+
+		// MaxConnections limits the number of concurrently open connections on each public TCP listener. Defaults to no limit. The admin listener is not limited.
+		MaxConnections int `yaml:"max_connections"`
+
+		// MaxConnectionsPolicy sets what happens to new connections at the MaxConnections limit: "block" (they wait in the kernel's accept queue) or "reject" (they are closed immediately). Defaults to "block".
+		MaxConnectionsPolicy string `yaml:"max_connections_policy"`
+
+		// KeepAlivePeriodSeconds sets the TCP keepalive period for accepted connections. Defaults to 180.
+		KeepAlivePeriodSeconds int `yaml:"keep_alive_period_seconds"`
+
+		// MaxHeaderBytes sets the maximum size of request headers, including the request line. Defaults to 1 MB.
+		MaxHeaderBytes int `yaml:"max_header_bytes"`
+
+		HTTP2 struct {
+			// MaxConcurrentStreams sets the maximum number of concurrent streams per HTTP/2 connection. Defaults to 250.
+			MaxConcurrentStreams uint32 `yaml:"max_concurrent_streams"`
+
+			// MaxReadFrameSize sets the largest HTTP/2 frame the server will read, between 16384 and 16777215. Defaults to 1 MB.
+			MaxReadFrameSize uint32 `yaml:"max_read_frame_size"`
+		} `yaml:"http2"`
+	}
+
+	Shutdown struct {
+		// TimeoutSeconds sets how long a graceful shutdown waits for in-flight requests to complete before closing connections. Defaults to 30.
+		TimeoutSeconds int `yaml:"timeout_seconds"`
+	}
+
 	Trace struct {
 		// Enabled, when true, enables distributed tracing using the
 		// OpenTracing framework.
@@ -126,13 +260,58 @@ type ServiceConfig struct {
 		// Tls, when true, causes the service to listen using HTTPS.
 		TLS bool `yaml:"tls"`
 
+		// PlaintextAddr, when set together with TLS, causes the service to also serve plain HTTP on this address. Addr remains the HTTPS address.
+		PlaintextAddr string `yaml:"plaintext_addr"`
+
+		// RedirectToTLS, when true, causes requests received on PlaintextAddr to be permanently redirected to HTTPS, except for paths in RedirectExemptPaths.
+		RedirectToTLS bool `yaml:"redirect_to_tls"`
+
+		// RedirectExemptPaths sets URI paths that, along with the paths beneath them, are served on PlaintextAddr without redirection, e.g. "/metrics".
+		RedirectExemptPaths []string `yaml:"redirect_exempt_paths"`
+
+		// RedirectPort sets the HTTPS port used in redirects, e.g. when a load balancer exposes the service on a different port. Defaults to the port of the HTTPS listener.
+		RedirectPort int `yaml:"redirect_port"`
+
 		// CertFilePath sets the path to the server's certificate file.
 		CertFilePath string `yaml:"cert_file_path"`
 
 		// KeyFilePath sets the path to the server's key file.
 		KeyFilePath string `yaml:"key_file_path"`
 
-		// CertWatcher monitor CertFilePath and KeyFilePath for changes
+		// Certificates sets additional certificate/key pairs. The certificate that matches the client's SNI server name is served; CertFilePath/KeyFilePath (or else the first pair) is the default.
+		Certificates []CertificateFilePaths
+
+		// SelfSigned, when true and no certificate paths are set, causes the service to generate an in-memory self-signed certificate. Intended for development and testing only.
+		SelfSigned bool `yaml:"self_signed"`
+
+		// CertExpiryWarningDays sets how many days before a certificate expires to begin logging warnings. Defaults to 30.
+		CertExpiryWarningDays int `yaml:"cert_expiry_warning_days"`
+
+		// CertDirPath sets a directory of additional certificate/key pairs, named "<name>.crt" and "<name>.key". Pairs added to the directory are loaded when it changes.
+		CertDirPath string `yaml:"cert_dir_path"`
+
+		// ClientCAFilePath sets the path to a PEM bundle of CA certificates used to verify client certificates. The bundle is reloaded according to CertWatcher.
+		ClientCAFilePath string `yaml:"client_ca_file_path"`
+
+		// ClientAuth sets the client certificate policy: "none", "request", "require", "verify_if_given" or "require_and_verify". Defaults to "require_and_verify" when ClientCAFilePath is set, otherwise "none". The subject of a verified client certificate is used as the request's caller id.
+		ClientAuth string `yaml:"client_auth"`
+
+		// MinVersion sets the minimum TLS version: "1.0", "1.1", "1.2" or "1.3". Defaults to Go's default (currently "1.2").
+		MinVersion string `yaml:"min_version"`
+
+		// MaxVersion sets the maximum TLS version: "1.0", "1.1", "1.2" or "1.3". Defaults to the newest version supported by Go.
+		MaxVersion string `yaml:"max_version"`
+
+		// CipherSuites sets the enabled TLS 1.0-1.2 cipher suites by IANA name, e.g. "TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256". TLS 1.3 cipher suites are not configurable. Defaults to Go's defaults.
+		CipherSuites []string `yaml:"cipher_suites"`
+
+		// CurvePreferences sets the enabled key exchange curves in preference order: "X25519", "X25519MLKEM768", "P-256", "P-384" or "P-521". Defaults to Go's defaults.
+		CurvePreferences []string `yaml:"curve_preferences"`
+
+		// NextProtos sets the ALPN protocols in preference order. Defaults to "http/1.1" and "h2".
+		NextProtos []string `yaml:"next_protos"`
+
+		// CertWatcher monitor certificate, key and ClientCAFilePath files for changes
 		CertWatcher struct {
 			// Disabled disable monitoring and automatic reloads when cert/key files are changed
 			Disabled bool `yaml:"disabled,omitempty"`
@@ -141,6 +320,14 @@ type ServiceConfig struct {
 		} `yaml:"cert_watcher"`
 	}
 
+	Upgrade struct {
+		// Enabled, when true, causes the service to perform a zero-downtime binary upgrade on SIGUSR2: the running executable is started again, inherits the service's listening sockets and, once it is ready, the old process drains and exits.
+		Enabled bool
+
+		// ReadyTimeoutSeconds sets how long to wait for the new process to become ready before abandoning the upgrade. Defaults to 30.
+		ReadyTimeoutSeconds int `yaml:"ready_timeout_seconds"`
+	}
+
 	Version struct {
 		// Min sets the minimum API version that the service supports.
 		Min int
@@ -150,6 +337,15 @@ type ServiceConfig struct {
 	}
 }
 
+// CertificateFilePaths holds the file paths of a certificate/key pair.
+type CertificateFilePaths struct {
+	// CertFilePath sets the path to the certificate file.
+	CertFilePath string `yaml:"cert_file_path"`
+
+	// KeyFilePath sets the path to the key file.
+	KeyFilePath string `yaml:"key_file_path"`
+}
+
 // Normalize applies sensible defaults to service config values when they are
 // otherwise unspecified or invalid.
 func (config *ServiceConfig) Normalize() {
@@ -157,13 +353,69 @@ func (config *ServiceConfig) Normalize() {
 		config.CORS.AllowedMethods = defaultCORSAllowedMethods
 	}
 
+	if config.Health.LivenessURIPath == "" {
+		config.Health.LivenessURIPath = defaultHealthLivenessURIPath
+	}
+
+	if config.Health.ReadinessURIPath == "" {
+		config.Health.ReadinessURIPath = defaultHealthReadinessURIPath
+	}
+
+	if config.Health.CheckTimeoutSeconds <= 0 {
+		config.Health.CheckTimeoutSeconds = defaultHealthCheckTimeoutSeconds
+	}
+
 	if config.Metrics.Enabled && config.Metrics.URIPath == "" {
 		config.Metrics.URIPath = defaultMetricsURIPath
 	}
 
+	if config.Paging.DefaultPageSize <= 0 {
+		config.Paging.DefaultPageSize = defaultPageSize
+	}
+
+	if config.Paging.MaxPageSize <= 0 {
+		config.Paging.MaxPageSize = defaultMaxPageSize
+	}
+
+	if config.Paging.MaxUnpagedItems <= 0 {
+		config.Paging.MaxUnpagedItems = defaultMaxUnpagedItems
+	}
+
 	if config.Profiler.Enabled && config.Profiler.URIPath == "" {
 		config.Profiler.URIPath = defaultProfilerURIPath
 	}
+
+	if config.Server.ReadHeaderTimeoutSeconds <= 0 {
+		config.Server.ReadHeaderTimeoutSeconds = defaultReadHeaderTimeoutSeconds
+	}
+
+	if config.Server.IdleTimeoutSeconds <= 0 {
+		config.Server.IdleTimeoutSeconds = defaultIdleTimeoutSeconds
+	}
+
+	if config.Server.KeepAlivePeriodSeconds <= 0 {
+		config.Server.KeepAlivePeriodSeconds = defaultKeepAlivePeriodSeconds
+	}
+
+	if config.Server.MaxConnectionsPolicy == "" {
+		config.Server.MaxConnectionsPolicy = maxConnectionsPolicyBlock
+	}
+
+	if config.ProxyProtocol.Enabled && config.ProxyProtocol.HeaderTimeoutSeconds <= 0 {
+		config.ProxyProtocol.HeaderTimeoutSeconds = defaultProxyHeaderTimeoutSeconds
+	}
+
+	if config.Shutdown.TimeoutSeconds <= 0 {
+		config.Shutdown.TimeoutSeconds = defaultShutdownTimeoutSeconds
+	}
+
+	if config.Transport.ClientCAFilePath != "" && config.Transport.ClientAuth == "" {
+		config.Transport.ClientAuth = "require_and_verify"
+	}
+
+	if config.Upgrade.Enabled && config.Upgrade.ReadyTimeoutSeconds <= 0 {
+		config.Upgrade.ReadyTimeoutSeconds = defaultUpgradeReadyTimeoutSeconds
+	}
 }
 
 // Validate sanity-checks service config values.
@@ -180,8 +432,74 @@ func (config *ServiceConfig) Validate() error {
 		return ErrMismatchedApiVersions
 	}
 
-	if config.Transport.TLS && (config.Transport.CertFilePath == "" || config.Transport.KeyFilePath == "") {
-		return ErrMissingTLSConfig
+	if config.Transport.TLS {
+		if (config.Transport.CertFilePath == "") != (config.Transport.KeyFilePath == "") {
+			return ErrMissingTLSConfig
+		}
+		if config.Transport.CertFilePath == "" && len(config.Transport.Certificates) == 0 && config.Transport.CertDirPath == "" && !config.Transport.SelfSigned {
+			return ErrMissingTLSConfig
+		}
+		for _, p := range config.Transport.Certificates {
+			if p.CertFilePath == "" || p.KeyFilePath == "" {
+				return ErrMissingTLSConfig
+			}
+		}
+	}
+
+	if config.Paging.DefaultPageSize > config.Paging.MaxPageSize {
+		return ErrInvalidDefaultPageSize
+	}
+
+	switch config.Server.MaxConnectionsPolicy {
+	case "", maxConnectionsPolicyBlock, maxConnectionsPolicyReject:
+	default:
+		return fmt.Errorf("unknown max connections policy '%s'", config.Server.MaxConnectionsPolicy)
+	}
+
+	if size := config.Server.HTTP2.MaxReadFrameSize; size != 0 && (size < 16384 || size > 16777215) {
+		return ErrInvalidHTTP2MaxReadFrameSize
+	}
+
+	if _, err := parseCIDRs(config.TrustedProxies); err != nil {
+		return err
+	}
+
+	if config.ProxyProtocol.Enabled {
+		if len(config.ProxyProtocol.TrustedCIDRs) == 0 {
+			return ErrMissingProxyProtocolTrustedCIDRs
+		}
+		if _, err := parseCIDRs(config.ProxyProtocol.TrustedCIDRs); err != nil {
+			return err
+		}
+	}
+
+	if config.Admin.Enabled {
+		if config.Admin.Addr == "" {
+			return ErrMissingAdminAddr
+		}
+		if config.Admin.TLS && !config.Transport.TLS {
+			return ErrAdminTLSWithoutTLS
+		}
+	}
+
+	if config.Transport.PlaintextAddr != "" && !config.Transport.TLS {
+		return ErrPlaintextAddrWithoutTLS
+	}
+
+	if port := config.Transport.RedirectPort; port < 0 || port > 65535 {
+		return ErrInvalidRedirectPort
+	}
+
+	if err := setTLSPolicy(new(tls.Config), config); err != nil {
+		return err
+	}
+
+	clientAuth, err := parseClientAuth(config.Transport.ClientAuth)
+	if err != nil {
+		return err
+	}
+	if clientAuth >= tls.VerifyClientCertIfGiven && config.Transport.ClientCAFilePath == "" {
+		return ErrMissingClientCAConfig
 	}
 
 	return nil
@@ -0,0 +1,132 @@
+package luddite
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"time"
+)
+
+const (
+	// CredentialCursorKey is the ServiceConfig.Credentials entry holding the
+	// key used to sign pagination cursors.
+	CredentialCursorKey = "cursor_key"
+
+	// MinCursorKeySize is the minimum length, in bytes, of cursor keys.
+	MinCursorKeySize = 32
+)
+
+var (
+	// ErrMissingCursorKey occurs when a cursor codec is requested from a
+	// service whose Credentials don't include a cursor key.
+	ErrMissingCursorKey = errors.New("must set the '" + CredentialCursorKey + "' credential to sign cursors")
+
+	// ErrCursorKeyTooShort occurs when a cursor codec is created with a key
+	// shorter than MinCursorKeySize.
+	ErrCursorKeyTooShort = errors.New("cursor key must be at least " + strconv.Itoa(MinCursorKeySize) + " bytes long")
+)
+
+// CursorCodec serializes values into opaque pagination cursors and back. Cursors
+// are base64url-encoded JSON signed with HMAC-SHA256, so that clients can
+// neither forge nor tamper with them, and may optionally expire. The signature
+// covers the codec's purpose, so a cursor issued for one collection is
+// rejected by the codec of another.
+type CursorCodec struct {
+	key     []byte
+	purpose string
+	ttl     time.Duration
+	now     func() time.Time
+}
+
+// cursorPayload is the signed content of a cursor.
+type cursorPayload struct {
+	Expires int64           `json:"e,omitempty"`
+	Value   json.RawMessage `json:"v"`
+}
+
+// NewCursorCodec returns a CursorCodec that signs cursors with key, which must
+// be at least MinCursorKeySize bytes long. The purpose, typically the name of
+// the collection being paged, is signed along with each cursor, and cursors are
+// only accepted by codecs with the same purpose. If ttl is positive, cursors
+// expire that long after they are encoded.
+func NewCursorCodec(key []byte, purpose string, ttl time.Duration) (*CursorCodec, error) {
+	if len(key) < MinCursorKeySize {
+		return nil, ErrCursorKeyTooShort
+	}
+	return &CursorCodec{
+		key:     key,
+		purpose: purpose,
+		ttl:     ttl,
+		now:     time.Now,
+	}, nil
+}
+
+// CursorCodec returns a CursorCodec for a purpose (see NewCursorCodec) using
+// the service's cursor key (see CredentialCursorKey) and
+// Paging.CursorTTLSeconds.
+func (s *Service) CursorCodec(purpose string) (*CursorCodec, error) {
+	key := s.config.Credentials[CredentialCursorKey]
+	if key == "" {
+		return nil, ErrMissingCursorKey
+	}
+	return NewCursorCodec([]byte(key), purpose, time.Duration(s.config.Paging.CursorTTLSeconds)*time.Second)
+}
+
+// Encode serializes v as JSON and returns it as a signed cursor.
+func (c *CursorCodec) Encode(v interface{}) (string, error) {
+	value, err := json.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+	p := cursorPayload{Value: value}
+	if c.ttl > 0 {
+		p.Expires = c.now().Add(c.ttl).Unix()
+	}
+	b, err := json.Marshal(&p)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(append(b, c.sign(b)...)), nil
+}
+
+// Decode verifies a cursor and deserializes its value into v. Cursors that are
+// malformed, tampered with, expired or issued for another purpose are rejected
+// with an EcodeInvalidParameterValue error, suitable for a 400 response. The
+// error doesn't include the cursor.
+func (c *CursorCodec) Decode(cursor string, v interface{}) error {
+	invalid := NewError(nil, EcodeInvalidParameterValue, "cursor", "invalid cursor")
+	b, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil || len(b) < sha256.Size {
+		return invalid
+	}
+	b, mac := b[:len(b)-sha256.Size], b[len(b)-sha256.Size:]
+	if !hmac.Equal(mac, c.sign(b)) {
+		return invalid
+	}
+
+	var p cursorPayload
+	if err = json.Unmarshal(b, &p); err != nil {
+		return invalid
+	}
+	if p.Expires != 0 && c.now().Unix() > p.Expires {
+		return invalid
+	}
+	if err = json.Unmarshal(p.Value, v); err != nil {
+		return invalid
+	}
+	return nil
+}
+
+// sign returns the MAC of a cursor payload. The purpose is length-prefixed so
+// that it can't run into the payload.
+func (c *CursorCodec) sign(b []byte) []byte {
+	h := hmac.New(sha256.New, c.key)
+	h.Write(binary.AppendUvarint(nil, uint64(len(c.purpose))))
+	h.Write([]byte(c.purpose))
+	h.Write(b)
+	return h.Sum(nil)
+}
@@ -0,0 +1,101 @@
+package luddite
+
+import (
+	"encoding/base64"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+type testCursor struct {
+	After string `json:"after"`
+	Page  int    `json:"page"`
+}
+
+var testCursorKey = []byte("0123456789abcdef0123456789abcdef")
+
+func newTestCursorCodec(t *testing.T, key []byte, purpose string, ttl time.Duration) *CursorCodec {
+	c, err := NewCursorCodec(key, purpose, ttl)
+	require.NoError(t, err)
+	return c
+}
+
+func TestCursorCodec(t *testing.T) {
+	_, err := NewCursorCodec([]byte("secret"), "users", 0)
+	require.ErrorIs(t, err, ErrCursorKeyTooShort)
+
+	c := newTestCursorCodec(t, testCursorKey, "users", 0)
+	cursor, err := c.Encode(&testCursor{After: "user-42", Page: 3})
+	require.NoError(t, err)
+	require.NotContains(t, cursor, "user-42")
+
+	var v testCursor
+	require.NoError(t, c.Decode(cursor, &v))
+	require.Equal(t, testCursor{After: "user-42", Page: 3}, v)
+
+	// Tampered and forged cursors are rejected
+	b, err := base64.RawURLEncoding.DecodeString(cursor)
+	require.NoError(t, err)
+	b[len(b)/2] ^= 1
+	for _, bad := range []string{
+		base64.RawURLEncoding.EncodeToString(b),
+		cursor[:len(cursor)-2],
+		"!!!",
+		"",
+	} {
+		err = c.Decode(bad, &v)
+		require.Error(t, err, bad)
+		require.Equal(t, EcodeInvalidParameterValue, err.(*Error).Code)
+		if bad != "" {
+			require.NotContains(t, err.Error(), bad)
+		}
+	}
+	forged, err := newTestCursorCodec(t, []byte("guess-guess-guess-guess-guess-guess"), "users", 0).Encode(&testCursor{After: "admin"})
+	require.NoError(t, err)
+	require.Error(t, c.Decode(forged, &v))
+
+	// Cursors are only accepted for the purpose they were issued for
+	other, err := newTestCursorCodec(t, testCursorKey, "groups", 0).Encode(&testCursor{After: "user-42"})
+	require.NoError(t, err)
+	require.Error(t, c.Decode(other, &v))
+}
+
+func TestCursorCodecExpiry(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	c := newTestCursorCodec(t, testCursorKey, "users", time.Minute)
+	c.now = func() time.Time { return now }
+	cursor, err := c.Encode("next")
+	require.NoError(t, err)
+
+	var v string
+	now = now.Add(time.Minute)
+	require.NoError(t, c.Decode(cursor, &v))
+	require.Equal(t, "next", v)
+
+	now = now.Add(time.Second)
+	err = c.Decode(cursor, &v)
+	require.Equal(t, EcodeInvalidParameterValue, err.(*Error).Code)
+}
+
+func TestServiceCursorCodec(t *testing.T) {
+	s := newTestService(t)
+	_, err := s.CursorCodec("users")
+	require.ErrorIs(t, err, ErrMissingCursorKey)
+
+	s.config.Credentials = map[string]string{CredentialCursorKey: "secret"}
+	_, err = s.CursorCodec("users")
+	require.ErrorIs(t, err, ErrCursorKeyTooShort)
+
+	s.config.Credentials = map[string]string{CredentialCursorKey: string(testCursorKey)}
+	s.config.Paging.CursorTTLSeconds = 60
+	c, err := s.CursorCodec("users")
+	require.NoError(t, err)
+	require.Equal(t, time.Minute, c.ttl)
+
+	cursor, err := c.Encode(7)
+	require.NoError(t, err)
+	var v int
+	require.NoError(t, newTestCursorCodec(t, testCursorKey, "users", 0).Decode(cursor, &v))
+	require.Equal(t, 7, v)
+}
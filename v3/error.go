@@ -22,6 +22,10 @@ const (
 	EcodeMissingViewParameter  = "MISSING_VIEW_PARAMETER"
 	EcodeInvalidViewParameter  = "INVALID_VIEW_PARAMETER"
 	EcodeInvalidParameterValue = "INVALID_PARAMETER_VALUE"
+	EcodePatchFailed           = "PATCH_FAILED"
+	EcodePreconditionFailed    = "PRECONDITION_FAILED"
+	EcodePreconditionRequired  = "PRECONDITION_REQUIRED"
+	EcodeCollectionTooLarge    = "COLLECTION_TOO_LARGE"
 )
 
 var commonErrorMap = map[string]string{
@@ -41,6 +45,10 @@ var commonErrorMap = map[string]string{
 	EcodeMissingViewParameter:  "Missing view parameter: %s",
 	EcodeInvalidViewParameter:  "Invalid view parameter: %s",
 	EcodeInvalidParameterValue: "Invalid parameter value: %s -> %s",
+	EcodePatchFailed:           "Patch failed: %s",
+	EcodePreconditionFailed:    "Precondition failed: %s",
+	EcodePreconditionRequired:  "Precondition required: %s",
+	EcodeCollectionTooLarge:    "Collection has more than %d elements; request it in pages",
 }
 
 // Error is a transfer object that is serialized as the body in 4xx and 5xx responses.
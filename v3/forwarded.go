@@ -0,0 +1,221 @@
+package luddite
+
+import (
+	"net"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+)
+
+// forwardedElement holds the parameters of one RFC 7239 Forwarded element, as
+// added by a single proxy.
+type forwardedElement struct {
+	forNode string
+	proto   string
+	host    string
+}
+
+// forwardedRequest describes the client side of a request, as reported by
+// trusted proxies.
+type forwardedRequest struct {
+	clientIP string
+	scheme   string
+	host     string
+	prefix   string
+}
+
+// RequestClientIP returns the IP address of the client that originated the
+// request. If the request was received from a trusted proxy (see
+// ServiceConfig.TrustedProxies), the Forwarded or X-Forwarded-For header is
+// followed back through trusted proxies to the first untrusted address.
+// Otherwise, the address of the connection's peer is returned.
+func RequestClientIP(r *http.Request) string {
+	return resolveForwarded(r).clientIP
+}
+
+// RequestExternalScheme returns the URL scheme ("http" or "https") used by the
+// client. If the request was received from a trusted proxy, the scheme reported
+// by the Forwarded or X-Forwarded-Proto header is returned. Otherwise, the
+// scheme is determined by the connection's TLS state.
+func RequestExternalScheme(r *http.Request) string {
+	return resolveForwarded(r).scheme
+}
+
+// RequestExternalHost returns the best estimation of the service's host name.
+// If the request was received from a trusted proxy, the host reported by the
+// Forwarded or X-Forwarded-Host header is returned. Otherwise, the Host member
+// from the http.Request is returned.
+func RequestExternalHost(r *http.Request) string {
+	return resolveForwarded(r).host
+}
+
+// RequestExternalURL returns the absolute URL of a path within the service, as
+// seen by the client. The URL is built from RequestExternalScheme,
+// RequestExternalHost, any X-Forwarded-Prefix sent by a trusted proxy that
+// strips a path prefix, and the service's configured Prefix, followed by the
+// given path elements. For example, RequestExternalURL(r, "users", "42") might
+// return "https://api.example.com/v1/users/42".
+func RequestExternalURL(r *http.Request, elem ...string) *url.URL {
+	fr := resolveForwarded(r)
+	var prefix string
+	if s := ContextService(r.Context()); s != nil {
+		prefix = s.config.Prefix
+	}
+	return &url.URL{
+		Scheme: fr.scheme,
+		Host:   fr.host,
+		Path:   path.Join(append([]string{"/", fr.prefix, prefix}, elem...)...),
+	}
+}
+
+// requestExternalPath returns the request's URL path relative to the
+// service's configured Prefix.
+func requestExternalPath(r *http.Request) string {
+	if s := ContextService(r.Context()); s != nil {
+		return strings.TrimPrefix(r.URL.Path, s.config.Prefix)
+	}
+	return r.URL.Path
+}
+
+// resolveForwarded walks the chain of proxies that forwarded a request, from
+// the connection's peer back towards the client, for as long as each hop is
+// trusted.
+func resolveForwarded(r *http.Request) *forwardedRequest {
+	fr := &forwardedRequest{
+		clientIP: remoteIP(r.RemoteAddr),
+		scheme:   "http",
+		host:     r.Host,
+	}
+	if r.TLS != nil {
+		fr.scheme = "https"
+	}
+
+	var trusted []*net.IPNet
+	if s := ContextService(r.Context()); s != nil {
+		trusted = s.trustedProxies
+	}
+	if !isTrustedNode(trusted, fr.clientIP) {
+		return fr
+	}
+	fr.prefix = lastListValue(r.Header.Values(HeaderForwardedPrefix))
+
+	// Prefer the standard Forwarded header over the X-Forwarded-* headers
+	var elements []forwardedElement
+	if values := r.Header.Values(HeaderForwarded); len(values) > 0 {
+		elements = parseForwarded(values)
+	} else {
+		for _, value := range r.Header.Values(HeaderForwardedFor) {
+			for _, node := range strings.Split(value, ",") {
+				elements = append(elements, forwardedElement{forNode: strings.TrimSpace(node)})
+			}
+		}
+		if proto := lastListValue(r.Header.Values(HeaderForwardedProto)); proto != "" {
+			fr.scheme = strings.ToLower(proto)
+		}
+		if host := lastListValue(r.Header.Values(HeaderForwardedHost)); host != "" {
+			fr.host = host
+		}
+	}
+
+	// Each element is added by a proxy that is trusted so far, so its
+	// parameters describe the hop towards the client
+	for i := len(elements) - 1; i >= 0; i-- {
+		e := elements[i]
+		if e.proto != "" {
+			fr.scheme = strings.ToLower(e.proto)
+		}
+		if e.host != "" {
+			fr.host = e.host
+		}
+		if e.forNode == "" {
+			break
+		}
+		fr.clientIP = forwardedNodeIP(e.forNode)
+		if !isTrustedNode(trusted, fr.clientIP) {
+			break
+		}
+	}
+	return fr
+}
+
+// parseForwarded parses RFC 7239 Forwarded header values into elements.
+func parseForwarded(values []string) (elements []forwardedElement) {
+	for _, value := range values {
+		for _, element := range splitQuoted(value, ',') {
+			var e forwardedElement
+			for _, pair := range splitQuoted(element, ';') {
+				k, v, ok := strings.Cut(strings.TrimSpace(pair), "=")
+				if !ok {
+					continue
+				}
+				v = strings.Trim(v, `"`)
+				switch strings.ToLower(k) {
+				case "for":
+					e.forNode = v
+				case "proto":
+					e.proto = v
+				case "host":
+					e.host = v
+				}
+			}
+			elements = append(elements, e)
+		}
+	}
+	return
+}
+
+// splitQuoted splits s at each sep that is not within a quoted string.
+func splitQuoted(s string, sep byte) (parts []string) {
+	var quoted bool
+	start := 0
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case c == '"':
+			quoted = !quoted
+		case c == '\\' && quoted:
+			i++
+		case c == sep && !quoted:
+			parts = append(parts, s[start:i])
+			start = i + 1
+		}
+	}
+	return append(parts, s[start:])
+}
+
+// forwardedNodeIP returns the IP address of a Forwarded "for" node, which may
+// include a port (e.g. "192.0.2.1:4711" or "[2001:db8::1]:4711"). Obfuscated
+// and "unknown" identifiers are returned as-is.
+func forwardedNodeIP(node string) string {
+	if ip := net.ParseIP(node); ip != nil {
+		return ip.String()
+	}
+	if host, _, err := net.SplitHostPort(node); err == nil {
+		node = host
+	}
+	node = strings.Trim(node, "[]")
+	if ip := net.ParseIP(node); ip != nil {
+		return ip.String()
+	}
+	return node
+}
+
+func remoteIP(remoteAddr string) string {
+	if host, _, err := net.SplitHostPort(remoteAddr); err == nil {
+		return host
+	}
+	return remoteAddr
+}
+
+func isTrustedNode(trusted []*net.IPNet, node string) bool {
+	ip := net.ParseIP(node)
+	return ip != nil && containsIP(trusted, ip)
+}
+
+func lastListValue(values []string) string {
+	if len(values) == 0 {
+		return ""
+	}
+	list := values[len(values)-1]
+	return strings.TrimSpace(list[strings.LastIndexByte(list, ',')+1:])
+}
@@ -0,0 +1,119 @@
+package luddite
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func newForwardedTestRequest(t *testing.T, trustedProxies []string, remoteAddr string, header http.Header) *http.Request {
+	s := newTestService(t)
+	var err error
+	s.trustedProxies, err = parseCIDRs(trustedProxies)
+	require.NoError(t, err)
+
+	req := httptest.NewRequest("GET", "http://service.local/users", nil)
+	req.RemoteAddr = remoteAddr
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	return req.WithContext(withHandlerDetails(req.Context(), &handlerDetails{s: s}))
+}
+
+func TestRequestForwardedUntrusted(t *testing.T) {
+	req := newForwardedTestRequest(t, []string{"10.0.0.0/8"}, "203.0.113.7:4000", http.Header{
+		HeaderForwardedFor:   {"192.0.2.1"},
+		HeaderForwardedProto: {"https"},
+		HeaderForwardedHost:  {"evil.example.com"},
+	})
+	require.Equal(t, "203.0.113.7", RequestClientIP(req))
+	require.Equal(t, "http", RequestExternalScheme(req))
+	require.Equal(t, "service.local", RequestExternalHost(req))
+
+	req.TLS = new(tls.ConnectionState)
+	require.Equal(t, "https", RequestExternalScheme(req))
+}
+
+func TestRequestXForwarded(t *testing.T) {
+	// The client's own X-Forwarded-For entry is ignored
+	req := newForwardedTestRequest(t, []string{"10.0.0.0/8"}, "10.0.0.2:4000", http.Header{
+		HeaderForwardedFor:   {"198.51.100.9, 203.0.113.7", "10.0.0.1"},
+		HeaderForwardedProto: {"https"},
+		HeaderForwardedHost:  {"api.example.com"},
+	})
+	require.Equal(t, "203.0.113.7", RequestClientIP(req))
+	require.Equal(t, "https", RequestExternalScheme(req))
+	require.Equal(t, "api.example.com", RequestExternalHost(req))
+
+	// Without forwarding headers, the trusted peer is the client
+	req = newForwardedTestRequest(t, []string{"10.0.0.0/8"}, "10.0.0.2:4000", nil)
+	require.Equal(t, "10.0.0.2", RequestClientIP(req))
+}
+
+func TestRequestForwarded(t *testing.T) {
+	req := newForwardedTestRequest(t, []string{"10.0.0.0/8", "2001:db8::/32"}, "[2001:db8::2]:4000", http.Header{
+		HeaderForwarded: {
+			`for=198.51.100.9;proto=http;host=evil.example.com`,
+			`for="203.0.113.7:4711";proto=https;host="api.example.com", for="[2001:db8::1]";proto=http`,
+		},
+		HeaderForwardedFor: {"192.0.2.1"},
+	})
+	require.Equal(t, "203.0.113.7", RequestClientIP(req))
+	require.Equal(t, "https", RequestExternalScheme(req))
+	require.Equal(t, "api.example.com", RequestExternalHost(req))
+
+	req = newForwardedTestRequest(t, []string{"10.0.0.0/8"}, "10.0.0.2:4000", http.Header{
+		HeaderForwarded: {"for=unknown;proto=https"},
+	})
+	require.Equal(t, "unknown", RequestClientIP(req))
+	require.Equal(t, "https", RequestExternalScheme(req))
+}
+
+func TestRequestExternalURL(t *testing.T) {
+	req := newForwardedTestRequest(t, []string{"10.0.0.0/8"}, "10.0.0.2:4000", http.Header{
+		HeaderForwardedProto:  {"https"},
+		HeaderForwardedHost:   {"api.example.com"},
+		HeaderForwardedPrefix: {"/svc"},
+	})
+	s := ContextService(req.Context())
+	s.config.Prefix = "/v1"
+	require.Equal(t, "https://api.example.com/svc/v1/users/42", RequestExternalURL(req, "users", "42").String())
+	require.Equal(t, "https://api.example.com/svc/v1", RequestExternalURL(req).String())
+
+	req.RemoteAddr = "203.0.113.7:4000"
+	require.Equal(t, "http://service.local/v1/users/42", RequestExternalURL(req, "users", "42").String())
+}
+
+type testCreateResource struct{}
+
+func (r *testCreateResource) New() interface{} {
+	return new(map[string]string)
+}
+
+func (r *testCreateResource) Id(_ interface{}) string {
+	return "42"
+}
+
+func (r *testCreateResource) Create(_ *http.Request, _ interface{}) (int, interface{}) {
+	return http.StatusCreated, nil
+}
+
+func TestCreateCollectionLocation(t *testing.T) {
+	s := newTestService(t)
+	s.config.Prefix = "/api"
+	s.apiRouters[1] = s.newRouter()
+	router, err := s.Router(1)
+	require.NoError(t, err)
+	AddCreateCollectionRoute(router, "/users", new(testCreateResource))
+
+	req := httptest.NewRequest("POST", "/api/users", nil)
+	req.Host = "service.local"
+	req = req.WithContext(withHandlerDetails(req.Context(), &handlerDetails{s: s}))
+	rw := httptest.NewRecorder()
+	router.ServeHTTP(rw, req)
+	require.Equal(t, http.StatusCreated, rw.Code)
+	require.Equal(t, "http://service.local/api/users/42", rw.Header().Get(HeaderLocation))
+}
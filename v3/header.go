@@ -1,16 +1,15 @@
 package luddite
 
 import (
-	"math"
 	"net/http"
 	"net/url"
-	"strconv"
 	"strings"
 )
 
 const (
 	HeaderAccept                 = "Accept"
 	HeaderAcceptEncoding         = "Accept-Encoding"
+	HeaderAcceptPatch            = "Accept-Patch"
 	HeaderAuthorization          = "Authorization"
 	HeaderCacheControl           = "Cache-Control"
 	HeaderContentDisposition     = "Content-Disposition"
@@ -19,13 +18,21 @@ const (
 	HeaderContentType            = "Content-Type"
 	HeaderETag                   = "ETag"
 	HeaderExpect                 = "Expect"
+	HeaderForwarded              = "Forwarded"
 	HeaderForwardedFor           = "X-Forwarded-For"
 	HeaderForwardedHost          = "X-Forwarded-Host"
+	HeaderForwardedPrefix        = "X-Forwarded-Prefix"
+	HeaderForwardedProto         = "X-Forwarded-Proto"
+	HeaderIfMatch                = "If-Match"
+	HeaderIfModifiedSince        = "If-Modified-Since"
 	HeaderIfNoneMatch            = "If-None-Match"
+	HeaderIfUnmodifiedSince      = "If-Unmodified-Since"
+	HeaderLastModified           = "Last-Modified"
 	HeaderLocation               = "Location"
 	HeaderRequestId              = "X-Request-Id"
 	HeaderSessionId              = "X-Session-Id"
 	HeaderSpirentApiVersion      = "X-Spirent-Api-Version"
+	HeaderSpirentInhibitPaging   = "X-Spirent-Inhibit-Paging"
 	HeaderSpirentInhibitResponse = "X-Spirent-Inhibit-Response"
 	HeaderSpirentNextLink        = "X-Spirent-Next-Link"
 	HeaderSpirentPageSize        = "X-Spirent-Page-Size"
@@ -43,17 +50,6 @@ func RequestBearerToken(r *http.Request) string {
 	return r.URL.Query().Get("access_token")
 }
 
-// RequestExternalHost returns the best estimation of the service's host name.
-// If a reverse proxy forwarded the request and populated the X-Forwarded-Host
-// header, then that value is returned. Otherwise, the Host member from the
-// http.Request is returned.
-func RequestExternalHost(r *http.Request) string {
-	if host := r.Header.Get(HeaderForwardedHost); host != "" {
-		return host
-	}
-	return r.Host
-}
-
 // RequestNextLink returns a url.URL value suitable for use in a response header
 // as the X-Spirent-Next-Link value. It combines the current http.Request URI
 // together with a "next page" cursor value.
@@ -65,17 +61,6 @@ func RequestNextLink(r *http.Request, cursor string) *url.URL {
 	return &next
 }
 
-// RequestPageSize returns the client's requested page size, defaulting to
-// math.MaxInt32 in cases where the X-Spirent-Page-Size header wasn't included
-// in the original request or when the header's value is <= 0.
-func RequestPageSize(r *http.Request) (pageSize int) {
-	var err error
-	if pageSize, err = strconv.Atoi(r.Header.Get(HeaderSpirentPageSize)); err != nil || pageSize <= 0 {
-		pageSize = math.MaxInt32
-	}
-	return
-}
-
 // RequestQueryCursor returns the "cursor" query string value from the
 // http.Request.
 func RequestQueryCursor(r *http.Request) string {
@@ -0,0 +1,181 @@
+package luddite
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	defaultHealthLivenessURIPath     = "/healthz"
+	defaultHealthReadinessURIPath    = "/readyz"
+	defaultHealthCheckTimeoutSeconds = 5
+
+	HealthStatusOK   = "ok"
+	HealthStatusWarn = "warn"
+	HealthStatusFail = "fail"
+)
+
+// HealthCheck checks one aspect of a service's health, e.g. connectivity to a
+// database. It returns nil when healthy. Checks must honor ctx, which is
+// canceled when the check's timeout elapses.
+type HealthCheck func(ctx context.Context) error
+
+// HealthReport is a transfer object that is serialized as the body of health
+// endpoint responses.
+type HealthReport struct {
+	Status string              `json:"status"`
+	Checks []HealthCheckResult `json:"checks,omitempty"`
+}
+
+// HealthCheckResult is the outcome of a single health check.
+type HealthCheckResult struct {
+	Name     string  `json:"name"`
+	Status   string  `json:"status"`
+	Critical bool    `json:"critical"`
+	Duration float64 `json:"duration_seconds"`
+	Error    string  `json:"error,omitempty"`
+}
+
+type healthCheck struct {
+	name     string
+	check    HealthCheck
+	timeout  time.Duration
+	critical bool
+}
+
+// AddHealthCheck registers a named health check that runs whenever the
+// service's readiness endpoint is requested. A failing critical check makes the
+// service not ready; a failing non-critical check is reported as a warning
+// only. Checks don't affect the liveness endpoint, which only reports that the
+// process is up, so that a failing dependency (e.g. a database) doesn't cause
+// an orchestrator to restart an otherwise healthy service. A timeout <= 0 uses
+// the configured default. All checks must be added before Run is called.
+func (s *Service) AddHealthCheck(name string, check HealthCheck, timeout time.Duration, critical bool) {
+	if timeout <= 0 {
+		timeout = time.Duration(s.config.Health.CheckTimeoutSeconds) * time.Second
+	}
+	s.healthChecks = append(s.healthChecks, &healthCheck{
+		name:     name,
+		check:    check,
+		timeout:  timeout,
+		critical: critical,
+	})
+}
+
+// Ready reports whether the service is ready to serve requests: it is false
+// until the service's listeners are open and becomes false again as soon as a
+// graceful shutdown begins. Health checks are not consulted.
+func (s *Service) Ready() bool {
+	return s.ready.Load()
+}
+
+func (s *Service) addHealthRoutes() {
+	router := s.AdminRouter()
+	router.GET(s.config.Health.LivenessURIPath, func(rw http.ResponseWriter, req *http.Request) {
+		s.serveHealth(rw, req, false)
+	})
+	router.GET(s.config.Health.ReadinessURIPath, func(rw http.ResponseWriter, req *http.Request) {
+		s.serveHealth(rw, req, true)
+	})
+}
+
+// serveHealth writes a health report. Liveness reports only that the process
+// is up; readiness runs the health checks and also fails while the service
+// isn't Ready.
+func (s *Service) serveHealth(rw http.ResponseWriter, req *http.Request, readiness bool) {
+	report := &HealthReport{Status: HealthStatusOK}
+	if readiness {
+		report = s.checkHealth(req.Context())
+		if !s.Ready() {
+			report.Status = HealthStatusFail
+		}
+	}
+
+	status := http.StatusOK
+	if report.Status == HealthStatusFail {
+		status = http.StatusServiceUnavailable
+	}
+	b, err := json.Marshal(report)
+	if err != nil {
+		rw.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	rw.Header().Set(HeaderContentType, ContentTypeJson)
+	rw.Header().Set(HeaderCacheControl, "no-cache")
+	rw.WriteHeader(status)
+	_, _ = rw.Write(b)
+}
+
+// checkHealth runs all health checks concurrently and summarizes the results.
+func (s *Service) checkHealth(ctx context.Context) *HealthReport {
+	report := &HealthReport{
+		Status: HealthStatusOK,
+		Checks: make([]HealthCheckResult, len(s.healthChecks)),
+	}
+
+	var wg sync.WaitGroup
+	for i, hc := range s.healthChecks {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			report.Checks[i] = hc.run(ctx)
+		}()
+	}
+	wg.Wait()
+
+	for _, result := range report.Checks {
+		switch result.Status {
+		case HealthStatusFail:
+			report.Status = HealthStatusFail
+		case HealthStatusWarn:
+			if report.Status == HealthStatusOK {
+				report.Status = HealthStatusWarn
+			}
+		}
+	}
+	return report
+}
+
+func (hc *healthCheck) run(ctx context.Context) (result HealthCheckResult) {
+	result = HealthCheckResult{
+		Name:     hc.name,
+		Status:   HealthStatusOK,
+		Critical: hc.critical,
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, hc.timeout)
+	defer cancel()
+
+	start := time.Now()
+	errs := make(chan error, 1)
+	go func() {
+		defer func() {
+			if rcv := recover(); rcv != nil {
+				errs <- fmt.Errorf("panic: %v", rcv)
+			}
+		}()
+		errs <- hc.check(ctx)
+	}()
+
+	var err error
+	select {
+	case err = <-errs:
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+	result.Duration = time.Since(start).Seconds()
+
+	if err != nil {
+		result.Error = err.Error()
+		if hc.critical {
+			result.Status = HealthStatusFail
+		} else {
+			result.Status = HealthStatusWarn
+		}
+	}
+	return
+}
@@ -0,0 +1,80 @@
+package luddite
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestHealthChecks(t *testing.T) {
+	s := newTestService(t)
+	s.AddHealthCheck("ok", func(context.Context) error { return nil }, 0, true)
+	s.AddHealthCheck("cache", func(context.Context) error { return errors.New("cache unavailable") }, 0, false)
+
+	report := s.checkHealth(context.Background())
+	require.Equal(t, HealthStatusWarn, report.Status)
+	require.Len(t, report.Checks, 2)
+	require.Equal(t, HealthStatusOK, report.Checks[0].Status)
+	require.Equal(t, HealthStatusWarn, report.Checks[1].Status)
+	require.Equal(t, "cache unavailable", report.Checks[1].Error)
+
+	s.AddHealthCheck("db", func(ctx context.Context) error {
+		<-ctx.Done()
+		return ctx.Err()
+	}, 10*time.Millisecond, true)
+	report = s.checkHealth(context.Background())
+	require.Equal(t, HealthStatusFail, report.Status)
+	require.Equal(t, HealthStatusFail, report.Checks[2].Status)
+	require.Equal(t, context.DeadlineExceeded.Error(), report.Checks[2].Error)
+}
+
+func TestHealthEndpoints(t *testing.T) {
+	s := newTestService(t)
+	s.AddHealthCheck("ok", func(context.Context) error { return nil }, 0, true)
+	dbErr := errors.New("db unavailable")
+	s.AddHealthCheck("db", func(context.Context) error { return dbErr }, 0, true)
+
+	serve := func(readiness bool) (int, *HealthReport) {
+		rw := httptest.NewRecorder()
+		s.serveHealth(rw, httptest.NewRequest("GET", "/", nil), readiness)
+		report := new(HealthReport)
+		require.NoError(t, json.Unmarshal(rw.Body.Bytes(), report))
+		return rw.Code, report
+	}
+
+	// The service is live but not ready before it runs
+	code, report := serve(false)
+	require.Equal(t, http.StatusOK, code)
+	require.Equal(t, HealthStatusOK, report.Status)
+	require.Empty(t, report.Checks)
+	code, report = serve(true)
+	require.Equal(t, http.StatusServiceUnavailable, code)
+	require.Equal(t, HealthStatusFail, report.Status)
+
+	done := make(chan error)
+	go func() { done <- s.RunContext(context.Background()) }()
+	require.Eventually(t, s.Ready, 5*time.Second, 10*time.Millisecond)
+
+	// A failing dependency makes the service not ready, but it stays live
+	code, report = serve(true)
+	require.Equal(t, http.StatusServiceUnavailable, code)
+	require.Len(t, report.Checks, 2)
+	code, _ = serve(false)
+	require.Equal(t, http.StatusOK, code)
+	dbErr = nil
+	code, _ = serve(true)
+	require.Equal(t, http.StatusOK, code)
+
+	// Readiness fails as soon as shutdown begins
+	require.NoError(t, s.Shutdown(context.Background()))
+	require.NoError(t, <-done)
+	require.False(t, s.Ready())
+	code, _ = serve(true)
+	require.Equal(t, http.StatusServiceUnavailable, code)
+}
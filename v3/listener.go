@@ -3,15 +3,29 @@ package luddite
 import (
 	"crypto/tls"
 	"errors"
+	"fmt"
 	"net"
 	"os"
 	"os/signal"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 )
 
-// Based on http://www.hydrogen18.com/blog/stop-listening-http-server-go.html,
-// but stops on SIGINT instead of explicit Stop() call
+const (
+	addrPrefixUnix = "unix:"
+	addrPrefixFd   = "fd:"
+
+	defaultKeepAlivePeriod = 3 * time.Minute
+)
+
+// Based on http://www.hydrogen18.com/blog/stop-listening-http-server-go.html.
+// A StoppableTCPListener is stopped by an explicit Stop() call or, when created
+// by NewStoppableTCPListener, on SIGINT. Once stopped, Accept returns a
+// ListenerStoppedError.
 
 type ListenerStoppedError struct{}
 
@@ -21,68 +35,240 @@ func (e *ListenerStoppedError) Error() string {
 
 type StoppableTCPListener struct {
 	*net.TCPListener
-	stop       chan os.Signal
-	keepalives bool
+	stopped         atomic.Bool
+	done            chan struct{}
+	closeOnce       sync.Once
+	keepalives      bool
+	keepAlivePeriod time.Duration
+	slots           chan struct{}
+	rejectWhenFull  bool
+	proxyProtocol   *proxyProtocol
+	addr            string
 }
 
 func (sl *StoppableTCPListener) Accept() (net.Conn, error) {
 	for {
-		// Wait up to one second for a new connection
-		err := sl.TCPListener.SetDeadline(time.Now().Add(time.Second))
-		if err != nil {
-			return nil, err
+		// When blocking at the connection limit, wait for a slot before
+		// accepting so that new connections queue in the kernel backlog
+		if sl.slots != nil && !sl.rejectWhenFull {
+			select {
+			case sl.slots <- struct{}{}:
+			case <-sl.done:
+				return nil, sl.closedError(net.ErrClosed)
+			}
 		}
-		newConn, err := sl.TCPListener.AcceptTCP()
 
-		// Check for the channel being closed
-		select {
-		case <-sl.stop:
-			return nil, &ListenerStoppedError{}
-		default:
-			// If nothing came in on the channel, continue as normal
+		newConn, err := sl.TCPListener.AcceptTCP()
+		if err != nil {
+			sl.releaseSlot()
+			return nil, sl.closedError(err)
 		}
 
-		if err != nil {
-			// If this is a timeout, then continue to wait for new connections
-			var e net.Error
-			if errors.As(err, &e) && e.Timeout() && e.Temporary() {
+		// When rejecting at the connection limit, close the new connection
+		// if no slot is available
+		if sl.slots != nil && sl.rejectWhenFull {
+			select {
+			case sl.slots <- struct{}{}:
+			default:
+				_ = newConn.Close()
+				listenerConnectionsRejected.WithLabelValues(sl.addr).Inc()
 				continue
 			}
-			return nil, err
 		}
 
 		if sl.keepalives {
 			_ = newConn.SetKeepAlive(true)
-			_ = newConn.SetKeepAlivePeriod(3 * time.Minute)
+			_ = newConn.SetKeepAlivePeriod(sl.keepAlivePeriod)
+		}
+		listenerConnectionsAccepted.WithLabelValues(sl.addr).Inc()
+		listenerConnectionsOpen.WithLabelValues(sl.addr).Inc()
+		conn := net.Conn(&trackedConn{TCPConn: newConn, sl: sl})
+		if sl.proxyProtocol != nil {
+			conn = sl.proxyProtocol.wrap(conn)
 		}
-		return newConn, err
+		return conn, nil
+	}
+}
+
+// closedError returns a ListenerStoppedError if the listener was stopped, and
+// err otherwise.
+func (sl *StoppableTCPListener) closedError(err error) error {
+	if sl.stopped.Load() {
+		return &ListenerStoppedError{}
+	}
+	return err
+}
+
+// Stop stops the listener: it is closed, and pending and future calls to
+// Accept return a ListenerStoppedError. Connections that were already accepted
+// remain open.
+func (sl *StoppableTCPListener) Stop() error {
+	sl.stopped.Store(true)
+	return sl.Close()
+}
+
+// Close closes the listener. Connections that were already accepted remain
+// open.
+func (sl *StoppableTCPListener) Close() error {
+	sl.closeOnce.Do(func() { close(sl.done) })
+	return sl.TCPListener.Close()
+}
+
+// SetKeepAlivePeriod sets the keepalive period for accepted connections when
+// keepalives are enabled. The default is three minutes.
+func (sl *StoppableTCPListener) SetKeepAlivePeriod(d time.Duration) {
+	sl.keepAlivePeriod = d
+}
+
+// SetMaxConnections limits the number of concurrently open connections
+// accepted by the listener. At the limit, Accept either blocks until a
+// connection closes or, if reject is true, immediately closes new connections.
+// A limit <= 0 removes the limit. It must be called before Accept.
+func (sl *StoppableTCPListener) SetMaxConnections(n int, reject bool) {
+	if n > 0 {
+		sl.slots = make(chan struct{}, n)
+	} else {
+		sl.slots = nil
 	}
+	sl.rejectWhenFull = reject
+}
+
+// SetProxyProtocol enables PROXY protocol (version 1 and 2) support for
+// connections from peers within the trusted networks. For these connections, a
+// PROXY protocol header is read, waiting up to timeout, and the client address
+// it carries is reported as the connection's remote address. Connections that
+// do not begin with a header are served as-is. Connections from other peers are
+// never parsed. It must be called before Accept.
+func (sl *StoppableTCPListener) SetProxyProtocol(trusted []*net.IPNet, timeout time.Duration) {
+	sl.proxyProtocol = &proxyProtocol{
+		trusted: trusted,
+		timeout: timeout,
+	}
+}
+
+func (sl *StoppableTCPListener) releaseSlot() {
+	if sl.slots != nil && !sl.rejectWhenFull {
+		<-sl.slots
+	}
+}
+
+// trackedConn is a connection accepted by a StoppableTCPListener. Closing it
+// releases its slot against the listener's connection limit.
+type trackedConn struct {
+	*net.TCPConn
+	sl        *StoppableTCPListener
+	closeOnce sync.Once
+}
+
+func (c *trackedConn) Close() error {
+	c.closeOnce.Do(func() {
+		if c.sl.slots != nil {
+			<-c.sl.slots
+		}
+		listenerConnectionsOpen.WithLabelValues(c.sl.addr).Dec()
+	})
+	return c.TCPConn.Close()
 }
 
 func NewStoppableTCPListener(addr string, keepalives bool) (net.Listener, error) {
-	l, err := net.Listen("tcp", addr)
+	sl, err := newStoppableTCPListener(addr, keepalives)
 	if err != nil {
 		return nil, err
 	}
 
-	sl := &StoppableTCPListener{
-		TCPListener: l.(*net.TCPListener),
-		stop:        make(chan os.Signal, 1),
-		keepalives:  keepalives,
-	}
-	signal.Notify(sl.stop, syscall.SIGINT)
+	// Stop on SIGINT
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGINT)
+	go func() {
+		select {
+		case <-sigs:
+			_ = sl.Stop()
+		case <-sl.done:
+		}
+		signal.Stop(sigs)
+	}()
 	return sl, nil
 }
 
 func NewStoppableTLSListener(addr string, keepalives bool, getCertificate func(hi *tls.ClientHelloInfo) (*tls.Certificate, error)) (net.Listener, error) {
-	tlsConfig := &tls.Config{
-		NextProtos:     []string{"http/1.1", "h2"},
-		GetCertificate: getCertificate,
+	stl, err := NewStoppableTCPListener(addr, keepalives)
+	if err != nil {
+		return nil, err
 	}
+	return tls.NewListener(stl, newTLSConfig(getCertificate)), nil
+}
 
-	stl, err := NewStoppableTCPListener(addr, keepalives)
+// Listen creates a listener for a service address. The address is normally a
+// TCP "host:port" pair. Addresses of the form "unix:/path/to/socket" listen on
+// a Unix domain socket, and addresses of the form "fd:N" listen on an inherited
+// file descriptor (e.g. "fd:3" for the first socket passed by systemd socket
+// activation). TCP connections optionally have keepalives enabled.
+func Listen(addr string, keepalives bool) (net.Listener, error) {
+	switch {
+	case strings.HasPrefix(addr, addrPrefixUnix):
+		return listenUnix(strings.TrimPrefix(addr, addrPrefixUnix))
+	case strings.HasPrefix(addr, addrPrefixFd):
+		return listenFd(strings.TrimPrefix(addr, addrPrefixFd), keepalives)
+	default:
+		return newStoppableTCPListener(addr, keepalives)
+	}
+}
+
+func listenUnix(path string) (net.Listener, error) {
+	// Remove a stale socket left behind by a previous process, but not one that
+	// another process is still serving on
+	if fi, err := os.Stat(path); err == nil && fi.Mode()&os.ModeSocket != 0 {
+		conn, err := net.Dial("unix", path)
+		if err == nil {
+			_ = conn.Close()
+		}
+		if !errors.Is(err, syscall.ECONNREFUSED) {
+			return nil, &net.OpError{Op: "listen", Net: "unix", Addr: &net.UnixAddr{Name: path, Net: "unix"}, Err: syscall.EADDRINUSE}
+		}
+		if err = os.Remove(path); err != nil {
+			return nil, err
+		}
+	}
+	return net.Listen("unix", path)
+}
+
+func listenFd(s string, keepalives bool) (net.Listener, error) {
+	fd, err := strconv.Atoi(s)
+	if err != nil || fd < 0 {
+		return nil, fmt.Errorf("invalid listener file descriptor '%s'", s)
+	}
+
+	f := os.NewFile(uintptr(fd), addrPrefixFd+s)
+	l, err := net.FileListener(f)
+	_ = f.Close() // net.FileListener dups the descriptor
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen on file descriptor %d: %s", fd, err)
+	}
+
+	if tl, ok := l.(*net.TCPListener); ok {
+		return newStoppableTCPListenerFrom(tl, keepalives), nil
+	}
+	return l, nil
+}
+
+// newStoppableTCPListener creates a StoppableTCPListener that is not bound to
+// any signal. It is stopped by calling Stop or closing it, e.g. via
+// http.Server.Shutdown.
+func newStoppableTCPListener(addr string, keepalives bool) (*StoppableTCPListener, error) {
+	l, err := net.Listen("tcp", addr)
 	if err != nil {
 		return nil, err
 	}
-	return tls.NewListener(stl, tlsConfig), nil
+
+	return newStoppableTCPListenerFrom(l.(*net.TCPListener), keepalives), nil
+}
+
+func newStoppableTCPListenerFrom(tl *net.TCPListener, keepalives bool) *StoppableTCPListener {
+	return &StoppableTCPListener{
+		TCPListener:     tl,
+		done:            make(chan struct{}),
+		keepalives:      keepalives,
+		keepAlivePeriod: defaultKeepAlivePeriod,
+		addr:            tl.Addr().String(),
+	}
 }
@@ -0,0 +1,135 @@
+package luddite
+
+import (
+	"io"
+	"net"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+)
+
+func acceptAsync(l net.Listener) <-chan net.Conn {
+	conns := make(chan net.Conn, 1)
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			close(conns)
+			return
+		}
+		conns <- conn
+	}()
+	return conns
+}
+
+func TestStoppableTCPListenerRejectsAtLimit(t *testing.T) {
+	sl, err := newStoppableTCPListener("127.0.0.1:0", true)
+	require.NoError(t, err)
+	defer sl.Close()
+	sl.SetMaxConnections(1, true)
+	rejected := testutil.ToFloat64(listenerConnectionsRejected.WithLabelValues(sl.addr))
+
+	client1, err := net.Dial("tcp", sl.addr)
+	require.NoError(t, err)
+	defer client1.Close()
+	conn1 := <-acceptAsync(sl)
+	require.NotNil(t, conn1)
+	require.Equal(t, 1.0, testutil.ToFloat64(listenerConnectionsOpen.WithLabelValues(sl.addr)))
+
+	// The second connection is closed by the listener
+	conns := acceptAsync(sl)
+	client2, err := net.Dial("tcp", sl.addr)
+	require.NoError(t, err)
+	require.NoError(t, client2.SetReadDeadline(time.Now().Add(5*time.Second)))
+	_, err = io.ReadAll(client2)
+	require.NoError(t, err)
+	_ = client2.Close()
+	require.Equal(t, rejected+1, testutil.ToFloat64(listenerConnectionsRejected.WithLabelValues(sl.addr)))
+
+	// Closing the first connection makes room for another
+	require.NoError(t, conn1.Close())
+	require.Equal(t, 0.0, testutil.ToFloat64(listenerConnectionsOpen.WithLabelValues(sl.addr)))
+	client3, err := net.Dial("tcp", sl.addr)
+	require.NoError(t, err)
+	defer client3.Close()
+	conn3 := <-conns
+	require.NotNil(t, conn3)
+	_ = conn3.Close()
+}
+
+func TestStoppableTCPListenerBlocksAtLimit(t *testing.T) {
+	sl, err := newStoppableTCPListener("127.0.0.1:0", true)
+	require.NoError(t, err)
+	sl.SetMaxConnections(1, false)
+
+	client1, err := net.Dial("tcp", sl.addr)
+	require.NoError(t, err)
+	defer client1.Close()
+	conn1 := <-acceptAsync(sl)
+	require.NotNil(t, conn1)
+
+	// The second connection waits until the first one closes
+	conns := acceptAsync(sl)
+	client2, err := net.Dial("tcp", sl.addr)
+	require.NoError(t, err)
+	defer client2.Close()
+	select {
+	case <-conns:
+		t.Fatal("connection accepted beyond the limit")
+	case <-time.After(100 * time.Millisecond):
+	}
+	require.NoError(t, conn1.Close())
+	conn2 := <-conns
+	require.NotNil(t, conn2)
+
+	// Closing the listener unblocks a waiting Accept
+	conns = acceptAsync(sl)
+	require.NoError(t, sl.Close())
+	_, ok := <-conns
+	require.False(t, ok)
+	_ = conn2.Close()
+}
+
+func TestStoppableTCPListenerStop(t *testing.T) {
+	sl, err := newStoppableTCPListener("127.0.0.1:0", true)
+	require.NoError(t, err)
+
+	errs := make(chan error, 1)
+	go func() {
+		_, acceptErr := sl.Accept()
+		errs <- acceptErr
+	}()
+	time.Sleep(10 * time.Millisecond)
+	require.NoError(t, sl.Stop())
+	select {
+	case err = <-errs:
+		var lse *ListenerStoppedError
+		require.ErrorAs(t, err, &lse)
+	case <-time.After(100 * time.Millisecond):
+		t.Fatal("Accept did not return promptly after Stop")
+	}
+
+	_, err = sl.Accept()
+	var lse *ListenerStoppedError
+	require.ErrorAs(t, err, &lse)
+}
+
+func TestListenUnixSocketInUse(t *testing.T) {
+	sockPath := filepath.Join(t.TempDir(), "service.sock")
+	l, err := Listen("unix:"+sockPath, false)
+	require.NoError(t, err)
+
+	// A socket that is still being served on isn't replaced
+	_, err = Listen("unix:"+sockPath, false)
+	require.ErrorIs(t, err, syscall.EADDRINUSE)
+
+	// A stale socket left behind by a previous process is
+	l.(*net.UnixListener).SetUnlinkOnClose(false)
+	require.NoError(t, l.Close())
+	l, err = Listen("unix:"+sockPath, false)
+	require.NoError(t, err)
+	require.NoError(t, l.Close())
+}
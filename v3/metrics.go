@@ -41,15 +41,55 @@ var (
 		Objectives: summaryObjectives,
 	}, []string{"method"})
 
+	tlsReloadsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Subsystem: "tls",
+		Name:      "reloads_total",
+		Help:      "Total number of TLS certificate and client CA reloads.",
+	}, []string{"kind", "result"})
+
+	tlsCertificateExpiry = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Subsystem: "tls",
+		Name:      "certificate_expiry_timestamp_seconds",
+		Help:      "The NotAfter time of loaded TLS certificates, in seconds since the epoch.",
+	}, []string{"certificate"})
+
+	listenerConnectionsOpen = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Subsystem: "listener",
+		Name:      "open_connections",
+		Help:      "Current number of open connections accepted by a listener.",
+	}, []string{"addr"})
+
+	listenerConnectionsAccepted = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Subsystem: "listener",
+		Name:      "accepted_connections_total",
+		Help:      "Total number of connections accepted by a listener.",
+	}, []string{"addr"})
+
+	listenerConnectionsRejected = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Subsystem: "listener",
+		Name:      "rejected_connections_total",
+		Help:      "Total number of connections rejected by a listener at its connection limit.",
+	}, []string{"addr"})
+
 	summaryObjectives = map[float64]float64{0.5: 0.05, 0.9: 0.01, 0.99: 0.001}
 )
 
+const (
+	tlsReloadCertificate = "certificate"
+	tlsReloadClientCA    = "client_ca"
+)
+
 func init() {
 	_ = prometheus.Register(httpRequestsTotal)
 	_ = prometheus.Register(httpRequestDuration)
 	_ = prometheus.Register(httpRequestsInFlight)
 	_ = prometheus.Register(httpRequestSizeBytes)
 	_ = prometheus.Register(httpResponseSizeBytes)
+	_ = prometheus.Register(tlsReloadsTotal)
+	_ = prometheus.Register(tlsCertificateExpiry)
+	_ = prometheus.Register(listenerConnectionsOpen)
+	_ = prometheus.Register(listenerConnectionsAccepted)
+	_ = prometheus.Register(listenerConnectionsRejected)
 }
 
 func instrumentHTTPHandler(h http.Handler) http.Handler {
@@ -59,3 +99,11 @@ func instrumentHTTPHandler(h http.Handler) http.Handler {
 				promhttp.InstrumentHandlerRequestSize(httpRequestSizeBytes,
 					promhttp.InstrumentHandlerResponseSize(httpResponseSizeBytes, h)))))
 }
+
+func observeTLSReload(kind string, err error) {
+	result := "success"
+	if err != nil {
+		result = "failure"
+	}
+	tlsReloadsTotal.WithLabelValues(kind, result).Inc()
+}
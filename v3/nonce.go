@@ -0,0 +1,63 @@
+package luddite
+
+import "net/http"
+
+// requireResourceNonce returns a request's X-Spirent-Resource-Nonce header.
+// Updates of resources with nonce support must carry a nonce, so if the header
+// is missing, it writes a 428 Precondition Required response and returns
+// false.
+func requireResourceNonce(rw http.ResponseWriter, req *http.Request) (string, bool) {
+	nonce := RequestResourceNonce(req)
+	if nonce == "" {
+		_ = WriteResponse(rw, http.StatusPreconditionRequired, NewError(nil, EcodePreconditionRequired, HeaderSpirentResourceNonce))
+		return "", false
+	}
+	return nonce, true
+}
+
+// nonceResponse sets the X-Spirent-Resource-Nonce header on the response to an
+// update of a resource with nonce support, using the resource's current nonce
+// as returned by nonce. Successful updates echo the new nonce, and updates
+// rejected with 409 Conflict because their nonce was stale echo the nonce that
+// the client should retry with. A 409 Conflict response without a body gets an
+// UPDATE_PREEMPTED error.
+func nonceResponse(rw http.ResponseWriter, status int, v interface{}, nonce func() string) interface{} {
+	if status/100 != 2 && status != http.StatusConflict {
+		return v
+	}
+	setResourceNonce(rw, nonce())
+	if status == http.StatusConflict && v == nil {
+		return NewError(nil, EcodeUpdatePreempted, "resource nonce is stale")
+	}
+	return v
+}
+
+func setResourceNonce(rw http.ResponseWriter, nonce string) {
+	if nonce != "" {
+		SetHeader(rw, HeaderSpirentResourceNonce, nonce)
+	}
+}
+
+// collectionNonceUpdater returns the CollectionNonceUpdater behind a
+// CollectionPatcher: either the patcher itself or, for patchers returned by
+// NewCollectionPatcher, the resource it updates.
+func collectionNonceUpdater(r CollectionPatcher) (CollectionNonceUpdater, bool) {
+	if p, ok := r.(*collectionPatcher); ok {
+		nu, ok := p.CollectionUpdater.(CollectionNonceUpdater)
+		return nu, ok
+	}
+	nu, ok := r.(CollectionNonceUpdater)
+	return nu, ok
+}
+
+// singletonNonceUpdater returns the SingletonNonceUpdater behind a
+// SingletonPatcher: either the patcher itself or, for patchers returned by
+// NewSingletonPatcher, the resource it updates.
+func singletonNonceUpdater(r SingletonPatcher) (SingletonNonceUpdater, bool) {
+	if p, ok := r.(*singletonPatcher); ok {
+		nu, ok := p.SingletonUpdater.(SingletonNonceUpdater)
+		return nu, ok
+	}
+	nu, ok := r.(SingletonNonceUpdater)
+	return nu, ok
+}
@@ -0,0 +1,167 @@
+package luddite
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// testNonceThingResource is a testThingResource that implements
+// CollectionNonceUpdater, deriving nonces from the value's version.
+type testNonceThingResource struct {
+	*testThingResource
+	lock sync.Mutex
+}
+
+func (r *testNonceThingResource) Nonce(_ *http.Request, _ string) string {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return r.nonce()
+}
+
+func (r *testNonceThingResource) UpdateIfNonce(req *http.Request, id, nonce string, value interface{}) (int, interface{}) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if nonce != r.nonce() {
+		return http.StatusConflict, nil
+	}
+	return r.Update(req, id, value)
+}
+
+func (r *testNonceThingResource) nonce() string {
+	if r.value == nil {
+		return ""
+	}
+	return "n" + strconv.Itoa(r.value.Version)
+}
+
+func TestCollectionNonceUpdater(t *testing.T) {
+	s := newTestService(t)
+	r := &testNonceThingResource{testThingResource: &testThingResource{value: &testThing{Id: "42", Name: "foo"}}}
+	require.NoError(t, s.AddResource(1, "/things", r))
+	router, err := s.Router(1)
+	require.NoError(t, err)
+	AddPatchCollectionRoute(router, "/things", NewCollectionPatcher(r))
+
+	do := func(method, nonce, body string) *httptest.ResponseRecorder {
+		h := make(http.Header)
+		if method == "PATCH" {
+			h.Set(HeaderContentType, ContentTypeMergePatch)
+		}
+		if nonce != "" {
+			h.Set(HeaderSpirentResourceNonce, nonce)
+		}
+		return serveTestRequest(t, s, 1, method, "/things/42", h, body)
+	}
+
+	rw := do("GET", "", "")
+	require.Equal(t, http.StatusOK, rw.Code)
+	require.Equal(t, "n0", rw.Header().Get(HeaderSpirentResourceNonce))
+
+	rw = do("PUT", "n0", `{"id":"42","name":"bar"}`)
+	require.Equal(t, http.StatusOK, rw.Code)
+	require.Equal(t, "n1", rw.Header().Get(HeaderSpirentResourceNonce))
+	require.Equal(t, "bar", r.value.Name)
+
+	// A second update based on the original nonce has been preempted
+	rw = do("PUT", "n0", `{"id":"42","name":"baz"}`)
+	require.Equal(t, http.StatusConflict, rw.Code)
+	require.Contains(t, rw.Body.String(), EcodeUpdatePreempted)
+	require.Equal(t, "n1", rw.Header().Get(HeaderSpirentResourceNonce))
+	require.Equal(t, "bar", r.value.Name)
+
+	// Updates without a nonce are rejected
+	rw = do("PUT", "", `{"id":"42","name":"baz"}`)
+	require.Equal(t, http.StatusPreconditionRequired, rw.Code)
+	require.Contains(t, rw.Body.String(), EcodePreconditionRequired)
+	require.Equal(t, "bar", r.value.Name)
+
+	// PATCH is subject to the same checks as PUT
+	rw = do("PATCH", "", `{"name":"qux"}`)
+	require.Equal(t, http.StatusPreconditionRequired, rw.Code)
+	require.Contains(t, rw.Body.String(), EcodePreconditionRequired)
+	require.Equal(t, "bar", r.value.Name)
+
+	rw = do("PATCH", "n0", `{"name":"qux"}`)
+	require.Equal(t, http.StatusConflict, rw.Code)
+	require.Contains(t, rw.Body.String(), EcodeUpdatePreempted)
+	require.Equal(t, "n1", rw.Header().Get(HeaderSpirentResourceNonce))
+	require.Equal(t, "bar", r.value.Name)
+
+	rw = do("PATCH", "n1", `{"name":"qux"}`)
+	require.Equal(t, http.StatusOK, rw.Code)
+	require.Equal(t, "n2", rw.Header().Get(HeaderSpirentResourceNonce))
+	require.Equal(t, "qux", r.value.Name)
+	require.Equal(t, 2, r.updates)
+}
+
+type testNonceSingleton struct {
+	value   testThing
+	version int
+	lock    sync.Mutex
+}
+
+func (r *testNonceSingleton) New() interface{} {
+	return new(testThing)
+}
+
+func (r *testNonceSingleton) Get(_ *http.Request) (int, interface{}) {
+	return http.StatusOK, &r.value
+}
+
+func (r *testNonceSingleton) Update(_ *http.Request, value interface{}) (int, interface{}) {
+	r.value = *value.(*testThing)
+	r.version++
+	return http.StatusNoContent, nil
+}
+
+func (r *testNonceSingleton) Nonce(_ *http.Request) string {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return "n" + strconv.Itoa(r.version)
+}
+
+func (r *testNonceSingleton) UpdateIfNonce(req *http.Request, nonce string, value interface{}) (int, interface{}) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if nonce != "n"+strconv.Itoa(r.version) {
+		return http.StatusConflict, nil
+	}
+	return r.Update(req, value)
+}
+
+func TestSingletonNonceUpdaterPatch(t *testing.T) {
+	s := newTestService(t)
+	r := &testNonceSingleton{value: testThing{Name: "foo"}}
+	require.NoError(t, s.AddResource(1, "/settings", r))
+	router, err := s.Router(1)
+	require.NoError(t, err)
+	AddPatchSingletonRoute(router, "/settings", NewSingletonPatcher(r))
+
+	patch := func(nonce string) *httptest.ResponseRecorder {
+		h := http.Header{HeaderContentType: {ContentTypeMergePatch}}
+		if nonce != "" {
+			h.Set(HeaderSpirentResourceNonce, nonce)
+		}
+		return serveTestRequest(t, s, 1, "PATCH", "/settings", h, `{"name":"bar"}`)
+	}
+
+	rw := patch("")
+	require.Equal(t, http.StatusPreconditionRequired, rw.Code)
+	require.Equal(t, "foo", r.value.Name)
+
+	rw = patch("stale")
+	require.Equal(t, http.StatusConflict, rw.Code)
+	require.Contains(t, rw.Body.String(), EcodeUpdatePreempted)
+	require.Equal(t, "n0", rw.Header().Get(HeaderSpirentResourceNonce))
+	require.Equal(t, "foo", r.value.Name)
+
+	rw = patch("n0")
+	require.Equal(t, http.StatusNoContent, rw.Code)
+	require.Equal(t, "n1", rw.Header().Get(HeaderSpirentResourceNonce))
+	require.Equal(t, "bar", r.value.Name)
+}
@@ -0,0 +1,135 @@
+package luddite
+
+import (
+	"math"
+	"net/http"
+	"reflect"
+	"strconv"
+)
+
+const (
+	defaultPageSize        = 100
+	defaultMaxPageSize     = 1000
+	defaultMaxUnpagedItems = 10000
+)
+
+// PageRequest describes a page of a collection requested by a client.
+type PageRequest struct {
+	// Size is the maximum number of elements to return. It is always between
+	// 1 and the service's Paging.MaxPageSize.
+	Size int
+
+	// Cursor is the opaque position of the page within the collection, as
+	// returned with the previous page, or an empty string for the first page.
+	Cursor string
+}
+
+// RequestPageRequest returns the page of a collection requested by the client,
+// using the X-Spirent-Page-Size header and the "cursor" query string value. The
+// page size is limited to the service's Paging.MaxPageSize, and the service's
+// Paging.DefaultPageSize is used in cases where the header wasn't included in
+// the original request or when the header's value is <= 0.
+func RequestPageRequest(r *http.Request) PageRequest {
+	defaultSize, maxSize := requestPageLimits(r)
+	pageSize := requestedPageSize(r)
+	if pageSize <= 0 {
+		pageSize = defaultSize
+	}
+	return PageRequest{
+		Size:   min(pageSize, maxSize),
+		Cursor: RequestQueryCursor(r),
+	}
+}
+
+// RequestPageSize returns the client's requested page size, defaulting to
+// math.MaxInt32 in cases where the X-Spirent-Page-Size header wasn't included
+// in the original request or when the header's value is <= 0. Unlike
+// RequestPageRequest, it doesn't apply the service's Paging limits.
+func RequestPageSize(r *http.Request) int {
+	if pageSize := requestedPageSize(r); pageSize > 0 {
+		return pageSize
+	}
+	return math.MaxInt32
+}
+
+// requestedPageSize returns the X-Spirent-Page-Size header value, or 0 if it
+// is missing or invalid.
+func requestedPageSize(r *http.Request) int {
+	pageSize, err := strconv.Atoi(r.Header.Get(HeaderSpirentPageSize))
+	if err != nil || pageSize < 0 {
+		return 0
+	}
+	return pageSize
+}
+
+// requestPageLimits returns the default and maximum page sizes of the service
+// handling a request.
+func requestPageLimits(r *http.Request) (defaultSize, maxSize int) {
+	if s := ContextService(r.Context()); s != nil {
+		return s.config.Paging.DefaultPageSize, s.config.Paging.MaxPageSize
+	}
+	return defaultPageSize, defaultMaxPageSize
+}
+
+// requestMaxUnpagedItems returns the maximum number of elements that the
+// service handling a request returns when paging is inhibited.
+func requestMaxUnpagedItems(r *http.Request) int {
+	if s := ContextService(r.Context()); s != nil {
+		return s.config.Paging.MaxUnpagedItems
+	}
+	return defaultMaxUnpagedItems
+}
+
+// RequestInhibitPaging returns true if the client sent an
+// X-Spirent-Inhibit-Paging header, asking for all elements of a collection in
+// a single response.
+func RequestInhibitPaging(r *http.Request) bool {
+	return r.Header.Get(HeaderSpirentInhibitPaging) != ""
+}
+
+// listAllPages collects every page of a collection into a single slice, for
+// requests that inhibit paging. Pages are requested with the maximum page size
+// and the elements of each page must be a slice. Collections with more than
+// the service's Paging.MaxUnpagedItems elements are rejected, as are listers
+// that return a cursor more than once.
+func listAllPages(req *http.Request, r CollectionPageLister) (int, interface{}) {
+	_, maxSize := requestPageLimits(req)
+	maxItems := requestMaxUnpagedItems(req)
+	page := PageRequest{Size: maxSize}
+	seen := map[string]bool{"": true}
+	var all reflect.Value
+	for {
+		status, v, next := r.ListPage(req, page)
+		if status != http.StatusOK {
+			return status, v
+		}
+		items := reflect.ValueOf(v)
+		if next == "" && !all.IsValid() {
+			if items.Kind() == reflect.Slice && items.Len() > maxItems {
+				return http.StatusBadRequest, NewError(nil, EcodeCollectionTooLarge, maxItems)
+			}
+			return status, v
+		}
+		if items.Kind() != reflect.Slice {
+			return http.StatusInternalServerError, NewError(nil, EcodeInternal, "paged list elements must be a slice")
+		}
+		if !all.IsValid() {
+			all = reflect.MakeSlice(items.Type(), 0, items.Len())
+		} else if items.Type() != all.Type() {
+			return http.StatusInternalServerError, NewError(nil, EcodeInternal, "paged list elements must have the same type on every page")
+		}
+		if all.Len()+items.Len() > maxItems {
+			return http.StatusBadRequest, NewError(nil, EcodeCollectionTooLarge, maxItems)
+		}
+		all = reflect.AppendSlice(all, items)
+
+		if next == "" {
+			return http.StatusOK, all.Interface()
+		}
+		if seen[next] {
+			return http.StatusInternalServerError, NewError(nil, EcodeInternal, "paged list returned a repeated cursor")
+		}
+		seen[next] = true
+		page.Cursor = next
+	}
+}
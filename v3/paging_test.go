@@ -0,0 +1,118 @@
+package luddite
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+type testPageResource struct {
+	items []int
+	pages []PageRequest
+}
+
+func (r *testPageResource) ListPage(_ *http.Request, page PageRequest) (int, interface{}, string) {
+	r.pages = append(r.pages, page)
+	start := 0
+	if page.Cursor != "" {
+		var err error
+		if start, err = strconv.Atoi(page.Cursor); err != nil {
+			return http.StatusBadRequest, NewError(nil, EcodeInvalidParameterValue, "cursor", page.Cursor), ""
+		}
+	}
+	end := min(start+page.Size, len(r.items))
+	var next string
+	if end < len(r.items) {
+		next = strconv.Itoa(end)
+	}
+	return http.StatusOK, r.items[start:end], next
+}
+
+func TestRequestPageSize(t *testing.T) {
+	req := httptest.NewRequest("GET", "/things?cursor=abc", nil)
+	require.Equal(t, math.MaxInt32, RequestPageSize(req))
+	require.Equal(t, PageRequest{Size: defaultPageSize, Cursor: "abc"}, RequestPageRequest(req))
+
+	s := newTestService(t)
+	s.config.Paging.DefaultPageSize = 10
+	s.config.Paging.MaxPageSize = 50
+	req = req.WithContext(withHandlerDetails(req.Context(), &handlerDetails{s: s}))
+	require.Equal(t, math.MaxInt32, RequestPageSize(req))
+	require.Equal(t, 10, RequestPageRequest(req).Size)
+	req.Header.Set(HeaderSpirentPageSize, "-1")
+	require.Equal(t, math.MaxInt32, RequestPageSize(req))
+	require.Equal(t, 10, RequestPageRequest(req).Size)
+	req.Header.Set(HeaderSpirentPageSize, "20")
+	require.Equal(t, 20, RequestPageSize(req))
+	require.Equal(t, 20, RequestPageRequest(req).Size)
+
+	// The legacy helper doesn't apply the service's limits
+	req.Header.Set(HeaderSpirentPageSize, "500")
+	require.Equal(t, 500, RequestPageSize(req))
+	require.Equal(t, 50, RequestPageRequest(req).Size)
+}
+
+func TestListPageCollectionRoute(t *testing.T) {
+	s := newTestService(t)
+	s.config.Paging.MaxPageSize = 4
+	r := &testPageResource{items: []int{1, 2, 3, 4, 5, 6, 7, 8, 9, 10}}
+	require.NoError(t, s.AddResource(1, "/things", r))
+
+	list := func(target string, header http.Header) ([]int, *httptest.ResponseRecorder) {
+		rw := serveTestRequest(t, s, 1, "GET", target, header, "")
+		require.Equal(t, http.StatusOK, rw.Code)
+		var items []int
+		require.NoError(t, json.Unmarshal(rw.Body.Bytes(), &items))
+		return items, rw
+	}
+
+	items, rw := list("/things?view=x", http.Header{HeaderSpirentPageSize: {"3"}})
+	require.Equal(t, []int{1, 2, 3}, items)
+	require.Equal(t, "/things?cursor=3&view=x", rw.Header().Get(HeaderSpirentNextLink))
+
+	items, rw = list("/things?cursor=3&view=x", http.Header{HeaderSpirentPageSize: {"100"}})
+	require.Equal(t, []int{4, 5, 6, 7}, items)
+	require.Equal(t, PageRequest{Size: 4, Cursor: "3"}, r.pages[len(r.pages)-1])
+	require.Equal(t, "/things?cursor=7&view=x", rw.Header().Get(HeaderSpirentNextLink))
+
+	items, rw = list("/things?cursor=7", nil)
+	require.Equal(t, []int{8, 9, 10}, items)
+	require.Empty(t, rw.Header().Get(HeaderSpirentNextLink))
+
+	r.pages = nil
+	items, rw = list("/things", http.Header{HeaderSpirentInhibitPaging: {"true"}})
+	require.Equal(t, r.items, items)
+	require.Empty(t, rw.Header().Get(HeaderSpirentNextLink))
+	require.Len(t, r.pages, 3)
+
+	// Collections larger than the unpaged limit must be requested in pages
+	s.config.Paging.MaxUnpagedItems = 9
+	rw = serveTestRequest(t, s, 1, "GET", "/things", http.Header{HeaderSpirentInhibitPaging: {"true"}}, "")
+	require.Equal(t, http.StatusBadRequest, rw.Code)
+	require.Contains(t, rw.Body.String(), EcodeCollectionTooLarge)
+}
+
+// testCyclicPageResource returns the same cursor for every page.
+type testCyclicPageResource struct {
+	pages int
+}
+
+func (r *testCyclicPageResource) ListPage(_ *http.Request, _ PageRequest) (int, interface{}, string) {
+	r.pages++
+	return http.StatusOK, []int{r.pages}, "again"
+}
+
+func TestListAllPagesCursorCycle(t *testing.T) {
+	s := newTestService(t)
+	r := new(testCyclicPageResource)
+	require.NoError(t, s.AddResource(1, "/things", r))
+
+	rw := serveTestRequest(t, s, 1, "GET", "/things", http.Header{HeaderSpirentInhibitPaging: {"true"}}, "")
+	require.Equal(t, http.StatusInternalServerError, rw.Code)
+	require.Equal(t, 2, r.pages)
+}
@@ -0,0 +1,483 @@
+package luddite
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+const acceptedPatchContentTypes = ContentTypeMergePatch + ", " + ContentTypeJsonPatch
+
+// Patch is a decoded PATCH request body: either a JSON Merge Patch (RFC 7396)
+// or a JSON Patch (RFC 6902).
+type Patch struct {
+	// ContentType is either ContentTypeMergePatch or ContentTypeJsonPatch.
+	ContentType string
+
+	// MergePatch holds the merge patch document, if ContentType is
+	// ContentTypeMergePatch.
+	MergePatch json.RawMessage
+
+	// Operations holds the JSON Patch operations, if ContentType is
+	// ContentTypeJsonPatch.
+	Operations []PatchOperation
+}
+
+// PatchOperation is a single JSON Patch (RFC 6902) operation.
+type PatchOperation struct {
+	Op    string          `json:"op"`
+	Path  string          `json:"path"`
+	From  string          `json:"from,omitempty"`
+	Value json.RawMessage `json:"value,omitempty"`
+}
+
+// ReadPatch deserializes a PATCH request body according to the Content-Type
+// header, which must be either application/merge-patch+json or
+// application/json-patch+json. JSON Patch operations are validated, but not
+// applied.
+func ReadPatch(req *http.Request) (*Patch, error) {
+	ct := req.Header.Get(HeaderContentType)
+	mt, _, _ := mime.ParseMediaType(ct)
+	body, err := io.ReadAll(req.Body)
+	if err != nil {
+		return nil, NewError(nil, EcodeDeserializationFailed, err)
+	}
+
+	p := &Patch{ContentType: mt}
+	switch mt {
+	case ContentTypeMergePatch:
+		if !json.Valid(body) {
+			return nil, NewError(nil, EcodeDeserializationFailed, "invalid JSON merge patch")
+		}
+		p.MergePatch = body
+	case ContentTypeJsonPatch:
+		if err = json.Unmarshal(body, &p.Operations); err != nil {
+			return nil, NewError(nil, EcodeDeserializationFailed, err)
+		}
+		for _, op := range p.Operations {
+			if err = op.validate(); err != nil {
+				return nil, NewError(nil, EcodeDeserializationFailed, err)
+			}
+		}
+	default:
+		return nil, NewError(nil, EcodeUnsupportedMediaType, ct)
+	}
+	return p, nil
+}
+
+// writePatchError writes the response for a PATCH request body that could not
+// be read. Unsupported media types are answered with 415 and an Accept-Patch
+// header listing the supported patch formats.
+func writePatchError(rw http.ResponseWriter, err error) {
+	if e, ok := err.(*Error); ok && e.Code == EcodeUnsupportedMediaType {
+		rw.Header().Set(HeaderAcceptPatch, acceptedPatchContentTypes)
+		_ = WriteResponse(rw, http.StatusUnsupportedMediaType, err)
+		return
+	}
+	_ = WriteResponse(rw, http.StatusBadRequest, err)
+}
+
+// Apply applies the patch to the JSON representation of current and stores the
+// result in target, which is typically a new instance of the resource.
+func (p *Patch) Apply(current, target interface{}) error {
+	doc, err := json.Marshal(current)
+	if err != nil {
+		return err
+	}
+	if doc, err = p.ApplyJSON(doc); err != nil {
+		return err
+	}
+	return json.Unmarshal(doc, target)
+}
+
+// ApplyJSON applies the patch to a JSON document and returns the patched
+// document.
+func (p *Patch) ApplyJSON(doc []byte) ([]byte, error) {
+	target, err := decodePatchJSON(doc)
+	if err != nil {
+		return nil, err
+	}
+
+	switch p.ContentType {
+	case ContentTypeMergePatch:
+		var patch interface{}
+		if patch, err = decodePatchJSON(p.MergePatch); err != nil {
+			return nil, err
+		}
+		target = mergePatch(target, patch)
+	case ContentTypeJsonPatch:
+		for _, op := range p.Operations {
+			if target, err = op.apply(target); err != nil {
+				return nil, err
+			}
+		}
+	default:
+		return nil, fmt.Errorf("unsupported patch type '%s'", p.ContentType)
+	}
+	return json.Marshal(target)
+}
+
+// mergePatch implements the JSON Merge Patch algorithm of RFC 7396.
+func mergePatch(target, patch interface{}) interface{} {
+	p, ok := patch.(map[string]interface{})
+	if !ok {
+		return patch
+	}
+	t, ok := target.(map[string]interface{})
+	if !ok {
+		t = make(map[string]interface{})
+	}
+	for k, v := range p {
+		if v == nil {
+			delete(t, k)
+		} else {
+			t[k] = mergePatch(t[k], v)
+		}
+	}
+	return t
+}
+
+func (op *PatchOperation) validate() error {
+	switch op.Op {
+	case "add", "replace", "test":
+		if op.Value == nil {
+			return fmt.Errorf("missing value in '%s' operation", op.Op)
+		}
+	case "move", "copy":
+		if _, err := parseJSONPointer(op.From); err != nil {
+			return err
+		}
+	case "remove":
+	default:
+		return fmt.Errorf("unknown patch operation '%s'", op.Op)
+	}
+	_, err := parseJSONPointer(op.Path)
+	return err
+}
+
+// apply applies the operation to a decoded JSON document and returns the
+// resulting document.
+func (op *PatchOperation) apply(doc interface{}) (interface{}, error) {
+	path, err := parseJSONPointer(op.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	var value interface{}
+	switch op.Op {
+	case "add", "replace", "test":
+		if value, err = decodePatchJSON(op.Value); err != nil {
+			return nil, err
+		}
+	case "move", "copy":
+		var from []string
+		if from, err = parseJSONPointer(op.From); err != nil {
+			return nil, err
+		}
+		if op.Op == "move" && strings.HasPrefix(op.Path+"/", op.From+"/") && op.Path != op.From {
+			return nil, fmt.Errorf("cannot move '%s' into one of its children", op.From)
+		}
+		if value, err = jsonPointerGet(doc, from); err != nil {
+			return nil, err
+		}
+		if op.Op == "move" {
+			if doc, _, err = jsonPointerRemove(doc, from); err != nil {
+				return nil, err
+			}
+		} else if value, err = copyJSON(value); err != nil {
+			return nil, err
+		}
+	}
+
+	switch op.Op {
+	case "add", "move", "copy":
+		return jsonPointerAdd(doc, path, value)
+	case "remove":
+		doc, _, err = jsonPointerRemove(doc, path)
+		return doc, err
+	case "replace":
+		if len(path) == 0 {
+			return value, nil
+		}
+		if doc, _, err = jsonPointerRemove(doc, path); err != nil {
+			return nil, err
+		}
+		return jsonPointerAdd(doc, path, value)
+	case "test":
+		var actual interface{}
+		if actual, err = jsonPointerGet(doc, path); err != nil {
+			return nil, err
+		}
+		if !equalJSON(actual, value) {
+			return nil, fmt.Errorf("test failed at '%s'", op.Path)
+		}
+		return doc, nil
+	default:
+		return nil, fmt.Errorf("unknown patch operation '%s'", op.Op)
+	}
+}
+
+// parseJSONPointer parses an RFC 6901 JSON Pointer into reference tokens. The
+// empty pointer refers to the whole document.
+func parseJSONPointer(s string) ([]string, error) {
+	if s == "" {
+		return nil, nil
+	}
+	if s[0] != '/' {
+		return nil, fmt.Errorf("invalid JSON pointer '%s'", s)
+	}
+	tokens := strings.Split(s[1:], "/")
+	for i, token := range tokens {
+		tokens[i] = strings.ReplaceAll(strings.ReplaceAll(token, "~1", "/"), "~0", "~")
+	}
+	return tokens, nil
+}
+
+func jsonPointerGet(doc interface{}, path []string) (interface{}, error) {
+	for _, token := range path {
+		switch node := doc.(type) {
+		case map[string]interface{}:
+			v, ok := node[token]
+			if !ok {
+				return nil, fmt.Errorf("path member '%s' not found", token)
+			}
+			doc = v
+		case []interface{}:
+			i, err := arrayIndex(token, len(node)-1)
+			if err != nil {
+				return nil, err
+			}
+			doc = node[i]
+		default:
+			return nil, fmt.Errorf("path member '%s' not found", token)
+		}
+	}
+	return doc, nil
+}
+
+func jsonPointerAdd(doc interface{}, path []string, value interface{}) (interface{}, error) {
+	if len(path) == 0 {
+		return value, nil
+	}
+	token := path[0]
+	switch node := doc.(type) {
+	case map[string]interface{}:
+		if len(path) == 1 {
+			node[token] = value
+			return node, nil
+		}
+		child, ok := node[token]
+		if !ok {
+			return nil, fmt.Errorf("path member '%s' not found", token)
+		}
+		child, err := jsonPointerAdd(child, path[1:], value)
+		if err != nil {
+			return nil, err
+		}
+		node[token] = child
+		return node, nil
+	case []interface{}:
+		if len(path) == 1 {
+			i := len(node)
+			if token != "-" {
+				var err error
+				if i, err = arrayIndex(token, len(node)); err != nil {
+					return nil, err
+				}
+			}
+			node = append(node, nil)
+			copy(node[i+1:], node[i:])
+			node[i] = value
+			return node, nil
+		}
+		i, err := arrayIndex(token, len(node)-1)
+		if err != nil {
+			return nil, err
+		}
+		if node[i], err = jsonPointerAdd(node[i], path[1:], value); err != nil {
+			return nil, err
+		}
+		return node, nil
+	default:
+		return nil, fmt.Errorf("path member '%s' not found", token)
+	}
+}
+
+func jsonPointerRemove(doc interface{}, path []string) (interface{}, interface{}, error) {
+	if len(path) == 0 {
+		return nil, nil, errors.New("cannot remove the whole document")
+	}
+	token := path[0]
+	switch node := doc.(type) {
+	case map[string]interface{}:
+		child, ok := node[token]
+		if !ok {
+			return nil, nil, fmt.Errorf("path member '%s' not found", token)
+		}
+		if len(path) == 1 {
+			delete(node, token)
+			return node, child, nil
+		}
+		child, removed, err := jsonPointerRemove(child, path[1:])
+		if err != nil {
+			return nil, nil, err
+		}
+		node[token] = child
+		return node, removed, nil
+	case []interface{}:
+		i, err := arrayIndex(token, len(node)-1)
+		if err != nil {
+			return nil, nil, err
+		}
+		if len(path) == 1 {
+			removed := node[i]
+			return append(node[:i], node[i+1:]...), removed, nil
+		}
+		var removed interface{}
+		if node[i], removed, err = jsonPointerRemove(node[i], path[1:]); err != nil {
+			return nil, nil, err
+		}
+		return node, removed, nil
+	default:
+		return nil, nil, fmt.Errorf("path member '%s' not found", token)
+	}
+}
+
+// arrayIndex parses an array index token, which must be in the range [0, max].
+func arrayIndex(token string, max int) (int, error) {
+	i, err := strconv.Atoi(token)
+	if err != nil || i < 0 || i > max || (len(token) > 1 && token[0] == '0') {
+		return 0, fmt.Errorf("invalid array index '%s'", token)
+	}
+	return i, nil
+}
+
+// decodePatchJSON decodes a JSON document, preserving numbers exactly.
+func decodePatchJSON(b []byte) (interface{}, error) {
+	decoder := json.NewDecoder(bytes.NewReader(b))
+	decoder.UseNumber()
+	var v interface{}
+	if err := decoder.Decode(&v); err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+func copyJSON(v interface{}) (interface{}, error) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	return decodePatchJSON(b)
+}
+
+// equalJSON compares decoded JSON values, treating numbers as equal if their
+// values are equal regardless of representation.
+func equalJSON(a, b interface{}) bool {
+	switch a := a.(type) {
+	case json.Number:
+		b, ok := b.(json.Number)
+		if !ok {
+			return false
+		}
+		af, aErr := a.Float64()
+		bf, bErr := b.Float64()
+		return aErr == nil && bErr == nil && af == bf
+	case map[string]interface{}:
+		b, ok := b.(map[string]interface{})
+		if !ok || len(a) != len(b) {
+			return false
+		}
+		for k, v := range a {
+			if bv, ok := b[k]; !ok || !equalJSON(v, bv) {
+				return false
+			}
+		}
+		return true
+	case []interface{}:
+		b, ok := b.([]interface{})
+		if !ok || len(a) != len(b) {
+			return false
+		}
+		for i := range a {
+			if !equalJSON(a[i], b[i]) {
+				return false
+			}
+		}
+		return true
+	default:
+		return reflect.DeepEqual(a, b)
+	}
+}
+
+type collectionPatcher struct {
+	CollectionGetter
+	CollectionUpdater
+}
+
+// NewCollectionPatcher returns a CollectionPatcher that applies patches to the
+// current value of an element, as returned by r.Get, and stores the result using
+// r.Update, or r.UpdateIfNonce with the request's nonce if r is a
+// CollectionNonceUpdater.
+func NewCollectionPatcher(r interface {
+	CollectionGetter
+	CollectionUpdater
+}) CollectionPatcher {
+	return &collectionPatcher{r, r}
+}
+
+func (p *collectionPatcher) Patch(req *http.Request, id string, patch *Patch) (int, interface{}) {
+	status, v0 := p.Get(req, id)
+	if status != http.StatusOK {
+		return status, v0
+	}
+	v1 := p.New()
+	if err := patch.Apply(v0, v1); err != nil {
+		return http.StatusUnprocessableEntity, NewError(nil, EcodePatchFailed, err)
+	}
+	if id != p.Id(v1) {
+		return http.StatusBadRequest, NewError(nil, EcodeResourceIdMismatch)
+	}
+	if nu, ok := p.CollectionUpdater.(CollectionNonceUpdater); ok {
+		return nu.UpdateIfNonce(req, id, RequestResourceNonce(req), v1)
+	}
+	return p.Update(req, id, v1)
+}
+
+type singletonPatcher struct {
+	SingletonGetter
+	SingletonUpdater
+}
+
+// NewSingletonPatcher returns a SingletonPatcher that applies patches to the
+// current value of a singleton, as returned by r.Get, and stores the result
+// using r.Update, or r.UpdateIfNonce with the request's nonce if r is a
+// SingletonNonceUpdater.
+func NewSingletonPatcher(r interface {
+	SingletonGetter
+	SingletonUpdater
+}) SingletonPatcher {
+	return &singletonPatcher{r, r}
+}
+
+func (p *singletonPatcher) Patch(req *http.Request, patch *Patch) (int, interface{}) {
+	status, v0 := p.Get(req)
+	if status != http.StatusOK {
+		return status, v0
+	}
+	v1 := p.New()
+	if err := patch.Apply(v0, v1); err != nil {
+		return http.StatusUnprocessableEntity, NewError(nil, EcodePatchFailed, err)
+	}
+	if nu, ok := p.SingletonUpdater.(SingletonNonceUpdater); ok {
+		return nu.UpdateIfNonce(req, RequestResourceNonce(req), v1)
+	}
+	return p.Update(req, v1)
+}
@@ -0,0 +1,126 @@
+package luddite
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestMergePatch(t *testing.T) {
+	p := &Patch{
+		ContentType: ContentTypeMergePatch,
+		MergePatch:  json.RawMessage(`{"title":"Hello!","phoneNumber":"+01-123-456-7890","author":{"familyName":null},"tags":["example"]}`),
+	}
+	doc, err := p.ApplyJSON([]byte(`{"title":"Goodbye!","author":{"givenName":"John","familyName":"Doe"},"tags":["example","sample"],"content":"This will be unchanged"}`))
+	require.NoError(t, err)
+	require.JSONEq(t, `{"title":"Hello!","author":{"givenName":"John"},"tags":["example"],"content":"This will be unchanged","phoneNumber":"+01-123-456-7890"}`, string(doc))
+}
+
+func TestJsonPatch(t *testing.T) {
+	var ops []PatchOperation
+	require.NoError(t, json.Unmarshal([]byte(`[
+		{"op":"test","path":"/a/b/c","value":"foo"},
+		{"op":"remove","path":"/a/b/c"},
+		{"op":"add","path":"/a/b/c","value":["foo","bar"]},
+		{"op":"replace","path":"/a/b/c","value":42},
+		{"op":"move","from":"/a/b/c","path":"/a/b/d"},
+		{"op":"copy","from":"/a/b/d","path":"/a/b/e"},
+		{"op":"add","path":"/list/1","value":2},
+		{"op":"add","path":"/list/-","value":4},
+		{"op":"remove","path":"/list/0"},
+		{"op":"add","path":"/m~1n","value":1.0},
+		{"op":"test","path":"/m~1n","value":1}
+	]`), &ops))
+	p := &Patch{ContentType: ContentTypeJsonPatch, Operations: ops}
+	doc, err := p.ApplyJSON([]byte(`{"a":{"b":{"c":"foo"}},"list":[1,3]}`))
+	require.NoError(t, err)
+	require.JSONEq(t, `{"a":{"b":{"d":42,"e":42}},"list":[2,3,4],"m/n":1.0}`, string(doc))
+
+	for _, op := range []string{
+		`{"op":"test","path":"/a","value":"bar"}`,
+		`{"op":"remove","path":"/missing"}`,
+		`{"op":"replace","path":"/missing","value":1}`,
+		`{"op":"add","path":"/list/5","value":1}`,
+		`{"op":"add","path":"/list/01","value":1}`,
+		`{"op":"move","from":"/a","path":"/a/b"}`,
+	} {
+		p.Operations = nil
+		require.NoError(t, json.Unmarshal([]byte("["+op+"]"), &p.Operations))
+		_, err = p.ApplyJSON([]byte(`{"a":"foo","list":[1]}`))
+		require.Error(t, err, op)
+	}
+}
+
+func TestReadPatch(t *testing.T) {
+	newRequest := func(ct, body string) *http.Request {
+		req := httptest.NewRequest("PATCH", "/users/42", strings.NewReader(body))
+		req.Header.Set(HeaderContentType, ct)
+		return req
+	}
+
+	p, err := ReadPatch(newRequest(ContentTypeMergePatch+"; charset=utf-8", `{"name":"x"}`))
+	require.NoError(t, err)
+	require.Equal(t, ContentTypeMergePatch, p.ContentType)
+	require.JSONEq(t, `{"name":"x"}`, string(p.MergePatch))
+
+	p, err = ReadPatch(newRequest(ContentTypeJsonPatch, `[{"op":"remove","path":"/name"}]`))
+	require.NoError(t, err)
+	require.Equal(t, []PatchOperation{{Op: "remove", Path: "/name"}}, p.Operations)
+
+	for _, body := range []string{`{`, `{"op":"remove"}`, `[{"op":"frob","path":"/a"}]`, `[{"op":"add","path":"/a"}]`, `[{"op":"remove","path":"a"}]`} {
+		_, err = ReadPatch(newRequest(ContentTypeJsonPatch, body))
+		require.Equal(t, EcodeDeserializationFailed, err.(*Error).Code, body)
+	}
+	_, err = ReadPatch(newRequest(ContentTypeMergePatch, `{`))
+	require.Equal(t, EcodeDeserializationFailed, err.(*Error).Code)
+	_, err = ReadPatch(newRequest(ContentTypeJson, `{}`))
+	require.Equal(t, EcodeUnsupportedMediaType, err.(*Error).Code)
+}
+
+func TestPatchCollectionRoute(t *testing.T) {
+	s := newTestService(t)
+	r := &testThingResource{value: &testThing{Id: "42", Name: "foo", Size: 1}}
+	require.NoError(t, s.AddResource(1, "/things", r))
+
+	// Resources without a patcher don't get a PATCH route implicitly
+	rw := serveTestRequest(t, s, 1, "PATCH", "/things/42", http.Header{HeaderContentType: {ContentTypeMergePatch}}, `{"name":"bar"}`)
+	require.Equal(t, http.StatusMethodNotAllowed, rw.Code)
+	require.Equal(t, "foo", r.value.Name)
+
+	router, err := s.Router(1)
+	require.NoError(t, err)
+	AddPatchCollectionRoute(router, "/things", NewCollectionPatcher(r))
+
+	patch := func(id, ct, body string) *httptest.ResponseRecorder {
+		return serveTestRequest(t, s, 1, "PATCH", "/things/"+id, http.Header{HeaderContentType: {ct}}, body)
+	}
+
+	rw = patch("42", ContentTypeMergePatch, `{"name":"bar"}`)
+	require.Equal(t, http.StatusOK, rw.Code)
+	require.Equal(t, "bar", r.value.Name)
+	require.Equal(t, 1, r.value.Size)
+
+	rw = patch("42", ContentTypeJsonPatch, `[{"op":"replace","path":"/size","value":2}]`)
+	require.Equal(t, http.StatusOK, rw.Code)
+	require.Equal(t, "bar", r.value.Name)
+	require.Equal(t, 2, r.value.Size)
+
+	rw = patch("43", ContentTypeMergePatch, `{"name":"baz"}`)
+	require.Equal(t, http.StatusNotFound, rw.Code)
+
+	rw = patch("42", ContentTypeJson, `{"name":"baz"}`)
+	require.Equal(t, http.StatusUnsupportedMediaType, rw.Code)
+	require.Equal(t, acceptedPatchContentTypes, rw.Header().Get(HeaderAcceptPatch))
+
+	rw = patch("42", ContentTypeJsonPatch, `[{"op":"test","path":"/size","value":3}]`)
+	require.Equal(t, http.StatusUnprocessableEntity, rw.Code)
+
+	rw = patch("42", ContentTypeMergePatch, `{"id":"43"}`)
+	require.Equal(t, http.StatusBadRequest, rw.Code)
+	require.Equal(t, "42", r.value.Id)
+	require.Equal(t, 2, r.updates)
+}
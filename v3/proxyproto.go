@@ -0,0 +1,240 @@
+package luddite
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	proxyV1Prefix    = "PROXY "
+	proxyV1MaxLength = 107
+
+	proxyV2CmdLocal = 0x0
+	proxyV2CmdProxy = 0x1
+	proxyV2TCP4     = 0x11
+	proxyV2TCP6     = 0x21
+)
+
+var (
+	proxyV2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+	errInvalidProxyHeader = errors.New("invalid PROXY protocol header")
+)
+
+// proxyProtocol holds a listener's PROXY protocol settings.
+type proxyProtocol struct {
+	trusted []*net.IPNet
+	timeout time.Duration
+}
+
+// wrap returns conn unchanged if its peer is not a trusted proxy. Otherwise it
+// returns a connection that reads an optional PROXY protocol header before any
+// other data and reports the client address given by the header as its remote
+// address.
+func (pp *proxyProtocol) wrap(conn net.Conn) net.Conn {
+	addr, ok := conn.RemoteAddr().(*net.TCPAddr)
+	if !ok || !containsIP(pp.trusted, addr.IP) {
+		return conn
+	}
+	return &proxyConn{
+		Conn:    conn,
+		r:       bufio.NewReader(conn),
+		timeout: pp.timeout,
+	}
+}
+
+// proxyConn is a connection from a trusted proxy. The PROXY protocol header is
+// read lazily, on the first call to Read or RemoteAddr, so that Accept is never
+// blocked by a slow client.
+type proxyConn struct {
+	net.Conn
+	r          *bufio.Reader
+	timeout    time.Duration
+	once       sync.Once
+	remoteAddr net.Addr
+	err        error
+}
+
+func (c *proxyConn) Read(b []byte) (int, error) {
+	c.once.Do(c.readHeader)
+	if c.err != nil {
+		return 0, c.err
+	}
+	return c.r.Read(b)
+}
+
+func (c *proxyConn) RemoteAddr() net.Addr {
+	c.once.Do(c.readHeader)
+	if c.remoteAddr != nil {
+		return c.remoteAddr
+	}
+	return c.Conn.RemoteAddr()
+}
+
+// CloseWrite shuts down the writing side of the connection, so that
+// half-closing a proxied connection works as it does for a *net.TCPConn.
+func (c *proxyConn) CloseWrite() error {
+	if cw, ok := c.Conn.(interface{ CloseWrite() error }); ok {
+		return cw.CloseWrite()
+	}
+	return errors.ErrUnsupported
+}
+
+func (c *proxyConn) readHeader() {
+	if c.timeout > 0 {
+		_ = c.Conn.SetReadDeadline(time.Now().Add(c.timeout))
+		defer func() { _ = c.Conn.SetReadDeadline(time.Time{}) }()
+	}
+
+	b, err := c.r.Peek(1)
+	if err != nil {
+		c.err = err
+		return
+	}
+	switch b[0] {
+	case proxyV1Prefix[0]:
+		if b, err = c.r.Peek(len(proxyV1Prefix)); err == nil && string(b) == proxyV1Prefix {
+			c.remoteAddr, c.err = readProxyV1Header(c.r)
+			return
+		}
+	case proxyV2Signature[0]:
+		if b, err = c.r.Peek(len(proxyV2Signature)); err == nil && bytes.Equal(b, proxyV2Signature) {
+			c.remoteAddr, c.err = readProxyV2Header(c.r)
+			return
+		}
+	}
+	// No header; the proxy passed the connection through as-is
+}
+
+// readProxyV1Header reads a human-readable (version 1) PROXY protocol header,
+// e.g. "PROXY TCP4 192.0.2.1 198.51.100.1 56324 443\r\n". It returns nil for
+// UNKNOWN connections. Both addresses must belong to the declared family.
+func readProxyV1Header(r *bufio.Reader) (net.Addr, error) {
+	var line []byte
+	for len(line) < proxyV1MaxLength {
+		b, err := r.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		line = append(line, b)
+		if b == '\n' {
+			break
+		}
+	}
+	if !bytes.HasSuffix(line, []byte("\r\n")) {
+		return nil, errInvalidProxyHeader
+	}
+
+	fields := strings.Fields(string(line))
+	if len(fields) >= 2 && fields[1] == "UNKNOWN" {
+		return nil, nil
+	}
+	if len(fields) != 6 || (fields[1] != "TCP4" && fields[1] != "TCP6") {
+		return nil, errInvalidProxyHeader
+	}
+	src, err := parseProxyV1Addr(fields[1], fields[2], fields[4])
+	if err != nil {
+		return nil, err
+	}
+	if _, err = parseProxyV1Addr(fields[1], fields[3], fields[5]); err != nil {
+		return nil, err
+	}
+	return src, nil
+}
+
+// parseProxyV1Addr parses an address and port from a version 1 header, checking
+// that the address belongs to family ("TCP4" or "TCP6").
+func parseProxyV1Addr(family, host, port string) (*net.TCPAddr, error) {
+	ip := net.ParseIP(host)
+	p, err := strconv.ParseUint(port, 10, 16)
+	if ip == nil || err != nil {
+		return nil, errInvalidProxyHeader
+	}
+	if isIPv4 := !strings.Contains(host, ":"); isIPv4 != (family == "TCP4") {
+		return nil, fmt.Errorf("%w: %s address '%s'", errInvalidProxyHeader, family, host)
+	}
+	return &net.TCPAddr{IP: ip, Port: int(p)}, nil
+}
+
+// readProxyV2Header reads a binary (version 2) PROXY protocol header. It
+// returns nil for LOCAL connections and for address families other than TCP
+// over IPv4 or IPv6.
+func readProxyV2Header(r *bufio.Reader) (net.Addr, error) {
+	var hdr [16]byte
+	if _, err := io.ReadFull(r, hdr[:]); err != nil {
+		return nil, err
+	}
+	if hdr[12]>>4 != 2 {
+		return nil, fmt.Errorf("%w: unsupported version %d", errInvalidProxyHeader, hdr[12]>>4)
+	}
+	payload := make([]byte, binary.BigEndian.Uint16(hdr[14:]))
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return nil, err
+	}
+
+	switch hdr[12] & 0xf {
+	case proxyV2CmdLocal:
+		return nil, nil
+	case proxyV2CmdProxy:
+	default:
+		return nil, errInvalidProxyHeader
+	}
+	switch hdr[13] {
+	case proxyV2TCP4:
+		if len(payload) < 12 {
+			return nil, errInvalidProxyHeader
+		}
+		return &net.TCPAddr{IP: net.IP(payload[0:4]), Port: int(binary.BigEndian.Uint16(payload[8:]))}, nil
+	case proxyV2TCP6:
+		if len(payload) < 36 {
+			return nil, errInvalidProxyHeader
+		}
+		return &net.TCPAddr{IP: net.IP(payload[0:16]), Port: int(binary.BigEndian.Uint16(payload[32:]))}, nil
+	default:
+		return nil, nil
+	}
+}
+
+// parseCIDRs parses a list of CIDRs. Bare IP addresses are treated as
+// single-address networks.
+func parseCIDRs(cidrs []string) ([]*net.IPNet, error) {
+	nets := make([]*net.IPNet, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		if !strings.Contains(cidr, "/") {
+			ip := net.ParseIP(cidr)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid CIDR '%s'", cidr)
+			}
+			bits := 8 * net.IPv6len
+			if ip4 := ip.To4(); ip4 != nil {
+				ip, bits = ip4, 8*net.IPv4len
+			}
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, n, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid CIDR '%s'", cidr)
+		}
+		nets = append(nets, n)
+	}
+	return nets, nil
+}
+
+func containsIP(nets []*net.IPNet, ip net.IP) bool {
+	for _, n := range nets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,139 @@
+package luddite
+
+import (
+	"encoding/binary"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+type testPeerConn struct {
+	net.Conn
+	peer net.Addr
+}
+
+func (c *testPeerConn) RemoteAddr() net.Addr {
+	return c.peer
+}
+
+// proxyTestConn returns a connection from peer that has been wrapped by pp,
+// with data already written by the peer.
+func proxyTestConn(t *testing.T, pp *proxyProtocol, peer string, data []byte) net.Conn {
+	server, client := net.Pipe()
+	t.Cleanup(func() {
+		_ = server.Close()
+		_ = client.Close()
+	})
+	go func() {
+		_, _ = client.Write(data)
+		_ = client.Close()
+	}()
+	addr, err := net.ResolveTCPAddr("tcp", peer)
+	require.NoError(t, err)
+	return pp.wrap(&testPeerConn{Conn: server, peer: addr})
+}
+
+func TestProxyProtocol(t *testing.T) {
+	trusted, err := parseCIDRs([]string{"10.0.0.0/8", "192.0.2.1"})
+	require.NoError(t, err)
+	pp := &proxyProtocol{trusted: trusted, timeout: time.Second}
+	const request = "GET / HTTP/1.1\r\n\r\n"
+
+	// Version 1
+	conn := proxyTestConn(t, pp, "10.1.2.3:4000", []byte("PROXY TCP4 203.0.113.7 10.1.1.1 56324 443\r\n"+request))
+	require.Equal(t, "203.0.113.7:56324", conn.RemoteAddr().String())
+	b, err := io.ReadAll(conn)
+	require.NoError(t, err)
+	require.Equal(t, request, string(b))
+
+	conn = proxyTestConn(t, pp, "10.1.2.3:4000", []byte("PROXY TCP6 2001:db8::7 2001:db8::1 56324 443\r\n"+request))
+	require.Equal(t, "[2001:db8::7]:56324", conn.RemoteAddr().String())
+
+	conn = proxyTestConn(t, pp, "10.1.2.3:4000", []byte("PROXY UNKNOWN\r\n"+request))
+	require.Equal(t, "10.1.2.3:4000", conn.RemoteAddr().String())
+
+	// Version 2
+	header := append([]byte(nil), proxyV2Signature...)
+	header = append(header, 0x20|proxyV2CmdProxy, proxyV2TCP4, 0, 12)
+	header = append(header, 203, 0, 113, 7, 10, 1, 1, 1)
+	header = binary.BigEndian.AppendUint16(header, 56324)
+	header = binary.BigEndian.AppendUint16(header, 443)
+	conn = proxyTestConn(t, pp, "192.0.2.1:4000", append(header, request...))
+	require.Equal(t, "203.0.113.7:56324", conn.RemoteAddr().String())
+	b, err = io.ReadAll(conn)
+	require.NoError(t, err)
+	require.Equal(t, request, string(b))
+
+	// A trusted proxy may pass connections through without a header
+	conn = proxyTestConn(t, pp, "10.1.2.3:4000", []byte(request))
+	require.Equal(t, "10.1.2.3:4000", conn.RemoteAddr().String())
+	b, err = io.ReadAll(conn)
+	require.NoError(t, err)
+	require.Equal(t, request, string(b))
+
+	// Malformed headers fail the connection, as do addresses that don't
+	// match the declared family
+	for _, header := range []string{
+		"PROXY TCP4 bogus\r\n",
+		"PROXY TCP4 2001:db8::7 10.1.1.1 56324 443\r\n",
+		"PROXY TCP4 203.0.113.7 2001:db8::1 56324 443\r\n",
+		"PROXY TCP6 203.0.113.7 2001:db8::1 56324 443\r\n",
+		"PROXY TCP6 2001:db8::7 2001:db8::1 56324 65536\r\n",
+	} {
+		conn = proxyTestConn(t, pp, "10.1.2.3:4000", []byte(header+request))
+		_, err = io.ReadAll(conn)
+		require.ErrorIs(t, err, errInvalidProxyHeader, header)
+	}
+
+	// Headers from untrusted peers are not parsed
+	data := "PROXY TCP4 203.0.113.7 10.1.1.1 56324 443\r\n" + request
+	conn = proxyTestConn(t, pp, "192.0.2.2:4000", []byte(data))
+	require.Equal(t, "192.0.2.2:4000", conn.RemoteAddr().String())
+	b, err = io.ReadAll(conn)
+	require.NoError(t, err)
+	require.Equal(t, data, string(b))
+}
+
+func TestProxyConnCloseWrite(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer func() { _ = l.Close() }()
+	client, err := net.Dial("tcp", l.Addr().String())
+	require.NoError(t, err)
+	defer func() { _ = client.Close() }()
+	server, err := l.Accept()
+	require.NoError(t, err)
+
+	trusted, err := parseCIDRs([]string{"127.0.0.1"})
+	require.NoError(t, err)
+	pp := &proxyProtocol{trusted: trusted, timeout: time.Second}
+	conn := pp.wrap(server)
+	defer func() { _ = conn.Close() }()
+
+	// Half-closing the proxied connection is seen by the peer as EOF, while
+	// the peer can still write
+	cw, ok := conn.(interface{ CloseWrite() error })
+	require.True(t, ok)
+	require.NoError(t, cw.CloseWrite())
+	_, err = client.Read(make([]byte, 1))
+	require.ErrorIs(t, err, io.EOF)
+	_, err = client.Write([]byte("x"))
+	require.NoError(t, err)
+}
+
+func TestParseCIDRs(t *testing.T) {
+	nets, err := parseCIDRs([]string{"10.0.0.0/8", "192.0.2.1", "2001:db8::1"})
+	require.NoError(t, err)
+	require.True(t, containsIP(nets, net.ParseIP("10.255.0.1")))
+	require.True(t, containsIP(nets, net.ParseIP("192.0.2.1")))
+	require.False(t, containsIP(nets, net.ParseIP("192.0.2.2")))
+	require.True(t, containsIP(nets, net.ParseIP("2001:db8::1")))
+
+	_, err = parseCIDRs([]string{"10.0.0.0/33"})
+	require.Error(t, err)
+	_, err = parseCIDRs([]string{"bogus"})
+	require.Error(t, err)
+}
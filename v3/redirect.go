@@ -0,0 +1,71 @@
+package luddite
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+type tlsRedirectHandler struct {
+	handler     http.Handler
+	tlsPort     string
+	exemptPaths []string
+}
+
+// newTLSRedirectHandler wraps a handler so that plain HTTP requests are
+// permanently redirected to HTTPS on tlsPort. Requests for paths beginning with
+// one of exemptPaths (e.g. health checks and metrics) are served without
+// redirection.
+func newTLSRedirectHandler(h http.Handler, tlsPort string, exemptPaths []string) http.Handler {
+	if tlsPort == "443" {
+		tlsPort = ""
+	}
+	return &tlsRedirectHandler{
+		handler:     h,
+		tlsPort:     tlsPort,
+		exemptPaths: exemptPaths,
+	}
+}
+
+// tlsRedirectPort returns the HTTPS port to redirect plain HTTP requests to:
+// the configured RedirectPort or, by default, the port that the HTTPS listener
+// is actually bound to. An error is returned if the listener's address has no
+// usable TCP port (e.g. a Unix domain socket).
+func tlsRedirectPort(config *ServiceConfig, tlsAddr net.Addr) (string, error) {
+	if config.Transport.RedirectPort > 0 {
+		return strconv.Itoa(config.Transport.RedirectPort), nil
+	}
+	if tcpAddr, ok := tlsAddr.(*net.TCPAddr); ok && tcpAddr.Port > 0 {
+		return strconv.Itoa(tcpAddr.Port), nil
+	}
+	return "", fmt.Errorf("cannot redirect to HTTPS listener address '%s'; set RedirectPort", tlsAddr)
+}
+
+func (h *tlsRedirectHandler) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
+	if req.TLS != nil || h.isExempt(req.URL.Path) {
+		h.handler.ServeHTTP(rw, req)
+		return
+	}
+
+	host := req.Host
+	if hostname, _, err := net.SplitHostPort(host); err == nil {
+		host = hostname
+	}
+	if h.tlsPort != "" {
+		host = net.JoinHostPort(strings.Trim(host, "[]"), h.tlsPort)
+	}
+	http.Redirect(rw, req, "https://"+host+req.URL.RequestURI(), http.StatusPermanentRedirect)
+}
+
+// isExempt reports whether path is one of the exempt paths or lies beneath
+// one, so that an exempt "/health" doesn't also exempt "/health-secrets".
+func (h *tlsRedirectHandler) isExempt(path string) bool {
+	for _, p := range h.exemptPaths {
+		if path == p || strings.HasPrefix(path, strings.TrimSuffix(p, "/")+"/") {
+			return true
+		}
+	}
+	return false
+}
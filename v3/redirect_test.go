@@ -0,0 +1,125 @@
+package luddite
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestTLSRedirectHandler(t *testing.T) {
+	ok := http.HandlerFunc(func(rw http.ResponseWriter, _ *http.Request) { rw.WriteHeader(http.StatusOK) })
+	h := newTLSRedirectHandler(ok, "8443", []string{"/metrics", "/healthz"})
+
+	req := httptest.NewRequest("POST", "http://example.com:8080/users?x=1", nil)
+	rw := httptest.NewRecorder()
+	h.ServeHTTP(rw, req)
+	require.Equal(t, http.StatusPermanentRedirect, rw.Code)
+	require.Equal(t, "https://example.com:8443/users?x=1", rw.Header().Get(HeaderLocation))
+
+	req = httptest.NewRequest("GET", "http://[::1]:8080/", nil)
+	rw = httptest.NewRecorder()
+	h.ServeHTTP(rw, req)
+	require.Equal(t, "https://[::1]:8443/", rw.Header().Get(HeaderLocation))
+
+	for path, code := range map[string]int{
+		"/metrics":         http.StatusOK,
+		"/metrics/":        http.StatusOK,
+		"/healthz/ready":   http.StatusOK,
+		"/healthz-admin":   http.StatusPermanentRedirect,
+		"/metrics-secrets": http.StatusPermanentRedirect,
+	} {
+		req = httptest.NewRequest("GET", "http://example.com:8080"+path, nil)
+		rw = httptest.NewRecorder()
+		h.ServeHTTP(rw, req)
+		require.Equal(t, code, rw.Code, path)
+	}
+
+	req = httptest.NewRequest("GET", "https://example.com:8443/users", nil)
+	req.TLS = new(tls.ConnectionState)
+	rw = httptest.NewRecorder()
+	h.ServeHTTP(rw, req)
+	require.Equal(t, http.StatusOK, rw.Code)
+
+	h = newTLSRedirectHandler(ok, "443", nil)
+	req = httptest.NewRequest("GET", "http://example.com/users", nil)
+	rw = httptest.NewRecorder()
+	h.ServeHTTP(rw, req)
+	require.Equal(t, "https://example.com/users", rw.Header().Get(HeaderLocation))
+}
+
+func TestTLSRedirectPort(t *testing.T) {
+	config := new(ServiceConfig)
+	port, err := tlsRedirectPort(config, &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 40443})
+	require.NoError(t, err)
+	require.Equal(t, "40443", port)
+
+	_, err = tlsRedirectPort(config, &net.UnixAddr{Name: "/tmp/service.sock", Net: "unix"})
+	require.Error(t, err)
+	_, err = tlsRedirectPort(config, &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	require.Error(t, err)
+
+	config.Transport.RedirectPort = 443
+	port, err = tlsRedirectPort(config, &net.UnixAddr{Name: "/tmp/service.sock", Net: "unix"})
+	require.NoError(t, err)
+	require.Equal(t, "443", port)
+}
+
+func TestServiceTLSRedirect(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	f, err := l.(*net.TCPListener).File()
+	require.NoError(t, err)
+	_ = l.Close()
+	defer f.Close()
+	inheritedPort := strconv.Itoa(l.Addr().(*net.TCPAddr).Port)
+
+	for _, addr := range []string{"127.0.0.1:0", fmt.Sprintf("fd:%d", f.Fd())} {
+		t.Run(addr, func(t *testing.T) {
+			config := new(ServiceConfig)
+			config.Addr = addr
+			config.Version.Min = 1
+			config.Version.Max = 1
+			config.Transport.TLS = true
+			config.Transport.SelfSigned = true
+			config.Transport.PlaintextAddr = "127.0.0.1:0"
+			config.Transport.RedirectToTLS = true
+			s, err := NewService(config, &ServiceConfigExt{
+				ServiceLogWriter: io.Discard,
+				AccessLogWriter:  io.Discard,
+			})
+			require.NoError(t, err)
+
+			ctx, cancel := context.WithCancel(context.Background())
+			done := make(chan error)
+			go func() { done <- s.RunContext(ctx) }()
+			tlsAddr := testListenerAddr(t, s, "http")
+			plaintextAddr := testListenerAddr(t, s, "plaintext")
+
+			client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+			res, err := client.Get("http://" + plaintextAddr.String() + "/users")
+			require.NoError(t, err)
+			_ = res.Body.Close()
+			require.Equal(t, http.StatusPermanentRedirect, res.StatusCode)
+			location, err := url.Parse(res.Header.Get(HeaderLocation))
+			require.NoError(t, err)
+			port := strconv.Itoa(tlsAddr.(*net.TCPAddr).Port)
+			require.NotEqual(t, "0", port)
+			require.Equal(t, port, location.Port())
+			if addr != "127.0.0.1:0" {
+				require.Equal(t, inheritedPort, port)
+			}
+
+			cancel()
+			require.NoError(t, <-done)
+		})
+	}
+}
@@ -2,7 +2,6 @@ package luddite
 
 import (
 	"net/http"
-	"net/url"
 	"path"
 
 	"github.com/dimfeld/httptreemux"
@@ -35,6 +34,39 @@ func AddListCollectionRoute(router *httptreemux.ContextMux, basePath string, r C
 	})
 }
 
+// CollectionPageLister is a collection-style resource that returns its
+// elements one page at a time in response to `GET /resource`. The route sets
+// the X-Spirent-Next-Link header when there are more pages. Clients that send
+// an X-Spirent-Inhibit-Paging header receive all elements in one response,
+// collected from every page.
+type CollectionPageLister interface {
+	// ListPage returns an HTTP status code, a slice of resources (or error)
+	// and the cursor of the next page, or an empty string on the last page.
+	ListPage(req *http.Request, page PageRequest) (int, interface{}, string)
+}
+
+// AddListPageCollectionRoute adds a route for a CollectionPageLister.
+func AddListPageCollectionRoute(router *httptreemux.ContextMux, basePath string, r CollectionPageLister) {
+	router.GET(basePath, func(rw http.ResponseWriter, req *http.Request) {
+		ctx := req.Context()
+		SetContextRequestProgress(ctx, "luddite.ListPageCollectionRoute.begin")
+		if RequestInhibitPaging(req) {
+			if status, v := listAllPages(req, r); status > 0 {
+				SetContextRequestProgress(ctx, "luddite.ListPageCollectionRoute.write")
+				_ = WriteResponse(rw, status, v)
+			}
+			return
+		}
+		if status, v, next := r.ListPage(req, RequestPageRequest(req)); status > 0 {
+			if next != "" && status == http.StatusOK {
+				SetHeader(rw, HeaderSpirentNextLink, RequestNextLink(req, next).String())
+			}
+			SetContextRequestProgress(ctx, "luddite.ListPageCollectionRoute.write")
+			_ = WriteResponse(rw, status, v)
+		}
+	})
+}
+
 // CollectionCounter is a collection-style resource that returns a count of its
 // elements in response to `GET /resource/all/count`.
 type CollectionCounter interface {
@@ -67,9 +99,13 @@ func AddGetCollectionRoute(router *httptreemux.ContextMux, basePath string, r Co
 		ctx := req.Context()
 		SetContextRequestProgress(ctx, "luddite.GetCollectionRoute.begin")
 		params := httptreemux.ContextParams(ctx)
-		if status, v := r.Get(req, params[RouteParamId]); status > 0 {
+		id := params[RouteParamId]
+		if status, v := r.Get(req, id); status > 0 {
+			if x, ok := r.(CollectionNonceUpdater); ok && status == http.StatusOK {
+				setResourceNonce(rw, x.Nonce(req, id))
+			}
 			SetContextRequestProgress(ctx, "luddite.GetCollectionRoute.write")
-			_ = WriteResponse(rw, status, v)
+			writeVersionedResponse(rw, req, status, v)
 		}
 	})
 }
@@ -100,15 +136,11 @@ func AddCreateCollectionRoute(router *httptreemux.ContextMux, basePath string, r
 		}
 		if status, v1 := r.Create(req, v0); status > 0 {
 			if status == http.StatusCreated {
-				url := url.URL{
-					Scheme: req.URL.Scheme,
-					Host:   req.URL.Host,
-					Path:   path.Join(req.URL.Path, r.Id(v1)),
-				}
-				AddHeader(rw, HeaderLocation, url.String())
+				location := RequestExternalURL(req, requestExternalPath(req), r.Id(v1))
+				AddHeader(rw, HeaderLocation, location.String())
 			}
 			SetContextRequestProgress(ctx, "luddite.CreateCollectionRoute.write")
-			_ = WriteResponse(rw, status, v1)
+			writeVersionedResponse(rw, req, status, v1)
 		}
 	})
 }
@@ -144,9 +176,94 @@ func AddUpdateCollectionRoute(router *httptreemux.ContextMux, basePath string, r
 			_ = WriteResponse(rw, http.StatusBadRequest, NewError(nil, EcodeResourceIdMismatch))
 			return
 		}
-		if status, v1 := r.Update(req, id, v0); status > 0 {
+		if !checkPreconditions(rw, req, collectionGetFunc(r, req, id)) {
+			SetContextRequestProgress(ctx, "luddite.UpdateCollectionRoute.precondition_error")
+			return
+		}
+		var (
+			status int
+			v1     interface{}
+		)
+		if nu, ok := r.(CollectionNonceUpdater); ok {
+			nonce, present := requireResourceNonce(rw, req)
+			if !present {
+				SetContextRequestProgress(ctx, "luddite.UpdateCollectionRoute.nonce_error")
+				return
+			}
+			status, v1 = nu.UpdateIfNonce(req, id, nonce, v0)
+			v1 = nonceResponse(rw, status, v1, func() string { return nu.Nonce(req, id) })
+		} else {
+			status, v1 = r.Update(req, id, v0)
+		}
+		if status > 0 {
 			SetContextRequestProgress(ctx, "luddite.UpdateCollectionRoute.write")
-			_ = WriteResponse(rw, status, v1)
+			writeVersionedResponse(rw, req, status, v1)
+		}
+	})
+}
+
+// CollectionNonceUpdater is a CollectionUpdater that supports optimistic
+// concurrency control using the X-Spirent-Resource-Nonce header. PUT requests
+// must carry the nonce of the element being updated, or they are rejected with
+// 428 Precondition Required, and are served by UpdateIfNonce instead of
+// Update. GET, PUT and PATCH responses include the current nonce. PATCH
+// requests must carry a nonce too; patchers returned by NewCollectionPatcher
+// store their result with UpdateIfNonce, while other patchers must compare
+// RequestResourceNonce with the element's nonce as part of the update.
+type CollectionNonceUpdater interface {
+	CollectionUpdater
+
+	// Nonce returns the current nonce of a specific element, or an empty
+	// string if the element doesn't exist.
+	Nonce(req *http.Request, id string) string
+
+	// UpdateIfNonce updates a specific element, provided that its current
+	// nonce matches nonce. The comparison and the update must be atomic with
+	// respect to other updates. If the nonce doesn't match, the element must
+	// be left unchanged and the status must be 409 Conflict. It returns an
+	// HTTP status code and an updated resource (or error).
+	UpdateIfNonce(req *http.Request, id, nonce string, value interface{}) (int, interface{})
+}
+
+// CollectionPatcher is a collection-style resource that partially updates a
+// specific element in response to `PATCH /resource/id`. AddResource only adds
+// a PATCH route for resources that implement it; NewCollectionPatcher provides
+// a default implementation for a CollectionGetter and CollectionUpdater.
+type CollectionPatcher interface {
+	// Patch returns an HTTP status code and a patched resource (or error).
+	Patch(req *http.Request, id string, patch *Patch) (int, interface{})
+}
+
+// AddPatchCollectionRoute adds a route for a CollectionPatcher.
+func AddPatchCollectionRoute(router *httptreemux.ContextMux, basePath string, r CollectionPatcher) {
+	router.PATCH(path.Join(basePath, ":"+RouteParamId), func(rw http.ResponseWriter, req *http.Request) {
+		ctx := req.Context()
+		SetContextRequestProgress(ctx, "luddite.PatchCollectionRoute.begin")
+		patch, err := ReadPatch(req)
+		if err != nil {
+			SetContextRequestProgress(ctx, "luddite.PatchCollectionRoute.body_error")
+			writePatchError(rw, err)
+			return
+		}
+		params := httptreemux.ContextParams(ctx)
+		id := params[RouteParamId]
+		if !checkPreconditions(rw, req, collectionGetFunc(r, req, id)) {
+			SetContextRequestProgress(ctx, "luddite.PatchCollectionRoute.precondition_error")
+			return
+		}
+		nu, nonced := collectionNonceUpdater(r)
+		if nonced {
+			if _, ok := requireResourceNonce(rw, req); !ok {
+				SetContextRequestProgress(ctx, "luddite.PatchCollectionRoute.nonce_error")
+				return
+			}
+		}
+		if status, v := r.Patch(req, id, patch); status > 0 {
+			if nonced {
+				v = nonceResponse(rw, status, v, func() string { return nu.Nonce(req, id) })
+			}
+			SetContextRequestProgress(ctx, "luddite.PatchCollectionRoute.write")
+			writeVersionedResponse(rw, req, status, v)
 		}
 	})
 }
@@ -165,7 +282,12 @@ func AddDeleteCollectionRoute(router *httptreemux.ContextMux, basePath string, r
 		ctx := req.Context()
 		SetContextRequestProgress(ctx, "luddite.DeleteCollectionRoute.begin")
 		params := httptreemux.ContextParams(ctx)
-		if status, v := r.Delete(req, params[RouteParamId]); status > 0 {
+		id := params[RouteParamId]
+		if !checkPreconditions(rw, req, collectionGetFunc(r, req, id)) {
+			SetContextRequestProgress(ctx, "luddite.DeleteCollectionRoute.precondition_error")
+			return
+		}
+		if status, v := r.Delete(req, id); status > 0 {
 			SetContextRequestProgress(ctx, "luddite.DeleteCollectionRoute.write")
 			_ = WriteResponse(rw, status, v)
 		}
@@ -213,8 +335,11 @@ func AddGetSingletonRoute(router *httptreemux.ContextMux, basePath string, r Sin
 		ctx := req.Context()
 		SetContextRequestProgress(ctx, "luddite.GetSingletonRoute.begin")
 		if status, v := r.Get(req); status > 0 {
+			if x, ok := r.(SingletonNonceUpdater); ok && status == http.StatusOK {
+				setResourceNonce(rw, x.Nonce(req))
+			}
 			SetContextRequestProgress(ctx, "luddite.GetSingletonRoute.write")
-			_ = WriteResponse(rw, status, v)
+			writeVersionedResponse(rw, req, status, v)
 		}
 	})
 }
@@ -240,9 +365,91 @@ func AddUpdateSingletonRoute(router *httptreemux.ContextMux, basePath string, r
 			_ = WriteResponse(rw, http.StatusBadRequest, err)
 			return
 		}
-		if status, v1 := r.Update(req, v0); status > 0 {
+		if !checkPreconditions(rw, req, singletonGetFunc(r, req)) {
+			SetContextRequestProgress(ctx, "luddite.UpdateSingletonRoute.precondition_error")
+			return
+		}
+		var (
+			status int
+			v1     interface{}
+		)
+		if nu, ok := r.(SingletonNonceUpdater); ok {
+			nonce, present := requireResourceNonce(rw, req)
+			if !present {
+				SetContextRequestProgress(ctx, "luddite.UpdateSingletonRoute.nonce_error")
+				return
+			}
+			status, v1 = nu.UpdateIfNonce(req, nonce, v0)
+			v1 = nonceResponse(rw, status, v1, func() string { return nu.Nonce(req) })
+		} else {
+			status, v1 = r.Update(req, v0)
+		}
+		if status > 0 {
 			SetContextRequestProgress(ctx, "luddite.UpdateSingletonRoute.write")
-			_ = WriteResponse(rw, status, v1)
+			writeVersionedResponse(rw, req, status, v1)
+		}
+	})
+}
+
+// SingletonNonceUpdater is a SingletonUpdater that supports optimistic
+// concurrency control using the X-Spirent-Resource-Nonce header. PUT requests
+// must carry the singleton's nonce, or they are rejected with 428 Precondition
+// Required, and are served by UpdateIfNonce instead of Update. GET, PUT and
+// PATCH responses include the current nonce. PATCH requests must carry a nonce
+// too; patchers returned by NewSingletonPatcher store their result with
+// UpdateIfNonce, while other patchers must compare RequestResourceNonce with
+// the singleton's nonce as part of the update.
+type SingletonNonceUpdater interface {
+	SingletonUpdater
+
+	// Nonce returns the singleton's current nonce.
+	Nonce(req *http.Request) string
+
+	// UpdateIfNonce updates the singleton, provided that its current nonce
+	// matches nonce. The comparison and the update must be atomic with
+	// respect to other updates. If the nonce doesn't match, the singleton
+	// must be left unchanged and the status must be 409 Conflict. It returns
+	// an HTTP status code and an updated resource (or error).
+	UpdateIfNonce(req *http.Request, nonce string, value interface{}) (int, interface{})
+}
+
+// SingletonPatcher is a singleton-style resource that is partially updated in
+// response to `PATCH /resource`. AddResource only adds a PATCH route for
+// resources that implement it; NewSingletonPatcher provides a default
+// implementation for a SingletonGetter and SingletonUpdater.
+type SingletonPatcher interface {
+	// Patch returns an HTTP status code and a patched resource (or error).
+	Patch(req *http.Request, patch *Patch) (int, interface{})
+}
+
+// AddPatchSingletonRoute adds a route for a SingletonPatcher.
+func AddPatchSingletonRoute(router *httptreemux.ContextMux, basePath string, r SingletonPatcher) {
+	router.PATCH(basePath, func(rw http.ResponseWriter, req *http.Request) {
+		ctx := req.Context()
+		SetContextRequestProgress(ctx, "luddite.PatchSingletonRoute.begin")
+		patch, err := ReadPatch(req)
+		if err != nil {
+			SetContextRequestProgress(ctx, "luddite.PatchSingletonRoute.body_error")
+			writePatchError(rw, err)
+			return
+		}
+		if !checkPreconditions(rw, req, singletonGetFunc(r, req)) {
+			SetContextRequestProgress(ctx, "luddite.PatchSingletonRoute.precondition_error")
+			return
+		}
+		nu, nonced := singletonNonceUpdater(r)
+		if nonced {
+			if _, ok := requireResourceNonce(rw, req); !ok {
+				SetContextRequestProgress(ctx, "luddite.PatchSingletonRoute.nonce_error")
+				return
+			}
+		}
+		if status, v := r.Patch(req, patch); status > 0 {
+			if nonced {
+				v = nonceResponse(rw, status, v, func() string { return nu.Nonce(req) })
+			}
+			SetContextRequestProgress(ctx, "luddite.PatchSingletonRoute.write")
+			writeVersionedResponse(rw, req, status, v)
 		}
 	})
 }
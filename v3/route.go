@@ -0,0 +1,92 @@
+package luddite
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+)
+
+const (
+	routeSuffixItem   = ".item"
+	routeSuffixCount  = ".count"
+	routeSuffixAction = ".action"
+)
+
+// ErrUnknownRoute occurs when a URL is requested for a route name that has not
+// been registered.
+var ErrUnknownRoute = errors.New("unknown route name")
+
+// registerRoute records the path pattern of a named route in an API version.
+// Routes added by AddResource are named after their base path, without leading
+// or trailing slashes, e.g. "users" for "/users". Routes for specific elements,
+// counts and actions append ".item", ".count" and ".action".
+func (s *Service) registerRoute(version int, basePath, suffix, pattern string) {
+	s.routes[version][strings.Trim(basePath, "/")+suffix] = pattern
+}
+
+// URL returns the path of a named route in the given API version, including the
+// service's configured Prefix. Params fill the route's path parameters in
+// order. Routes added by AddResource are named after their base path, e.g.
+// "users" for "/users", with ".item", ".count" and ".action" appended for
+// routes to specific elements, element counts and actions. For example,
+// URL(2, "users.item", "42") might return "/users/42". Routes added directly
+// with the Add*Route functions are not named.
+func (s *Service) URL(version int, name string, params ...string) (string, error) {
+	p, err := s.routePath(version, name, params)
+	if err != nil {
+		return "", err
+	}
+	return path.Join("/", s.config.Prefix, p), nil
+}
+
+// ExternalURL returns the absolute URL of a named route in the given API
+// version, as seen by the client that sent req. See URL and RequestExternalURL.
+func (s *Service) ExternalURL(req *http.Request, version int, name string, params ...string) (*url.URL, error) {
+	p, err := s.routePath(version, name, params)
+	if err != nil {
+		return nil, err
+	}
+	// Route parameters are already escaped, e.g. "/" in an identifier
+	u := RequestExternalURL(req)
+	u.RawPath = path.Join(u.EscapedPath(), p)
+	if u.Path, err = url.PathUnescape(u.RawPath); err != nil {
+		return nil, err
+	}
+	return u, nil
+}
+
+// routePath returns the escaped path of a named route, relative to the
+// service's configured Prefix.
+func (s *Service) routePath(version int, name string, params []string) (string, error) {
+	if _, err := s.Router(version); err != nil {
+		return "", err
+	}
+
+	pattern, ok := s.routes[version][name]
+	if !ok {
+		return "", fmt.Errorf("%w '%s'", ErrUnknownRoute, name)
+	}
+
+	segments := strings.Split(pattern, "/")
+	for i, segment := range segments {
+		if segment == "" || (segment[0] != ':' && segment[0] != '*') {
+			continue
+		}
+		if len(params) == 0 {
+			return "", fmt.Errorf("missing value for parameter '%s' of route '%s'", segment[1:], name)
+		}
+		if segment[0] == '*' {
+			segments[i] = params[0]
+		} else {
+			segments[i] = url.PathEscape(params[0])
+		}
+		params = params[1:]
+	}
+	if len(params) > 0 {
+		return "", fmt.Errorf("too many parameters for route '%s'", name)
+	}
+	return path.Join("/", strings.Join(segments, "/")), nil
+}
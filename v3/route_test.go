@@ -0,0 +1,63 @@
+package luddite
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+type testUserResource struct{}
+
+func (r *testUserResource) List(_ *http.Request) (int, interface{}) {
+	return http.StatusOK, nil
+}
+
+func (r *testUserResource) Get(_ *http.Request, _ string) (int, interface{}) {
+	return http.StatusOK, nil
+}
+
+func (r *testUserResource) Action(_ *http.Request, _ string, _ string) (int, interface{}) {
+	return http.StatusOK, nil
+}
+
+func TestServiceURL(t *testing.T) {
+	s := newTestService(t)
+	s.config.Prefix = "/api"
+	require.NoError(t, s.AddResource(1, "/users", new(testUserResource)))
+	require.NoError(t, s.AddResource(1, "/groups/:groupId/members/", new(testUserResource)))
+
+	u, err := s.URL(1, "users")
+	require.NoError(t, err)
+	require.Equal(t, "/api/users", u)
+
+	u, err = s.URL(1, "users.item", "42")
+	require.NoError(t, err)
+	require.Equal(t, "/api/users/42", u)
+
+	u, err = s.URL(1, "users.action", "a b", "reset")
+	require.NoError(t, err)
+	require.Equal(t, "/api/users/a%20b/reset", u)
+
+	u, err = s.URL(1, "groups/:groupId/members.item", "admins", "42")
+	require.NoError(t, err)
+	require.Equal(t, "/api/groups/admins/members/42", u)
+
+	req := newForwardedTestRequest(t, nil, "203.0.113.7:4000", nil)
+	req = req.WithContext(withHandlerDetails(req.Context(), &handlerDetails{s: s}))
+	ext, err := s.ExternalURL(req, 1, "users.item", "42")
+	require.NoError(t, err)
+	require.Equal(t, "http://service.local/api/users/42", ext.String())
+	ext, err = s.ExternalURL(req, 1, "users.action", "a/b c", "reset")
+	require.NoError(t, err)
+	require.Equal(t, "http://service.local/api/users/a%2Fb%20c/reset", ext.String())
+
+	_, err = s.URL(1, "users.item")
+	require.Error(t, err)
+	_, err = s.URL(1, "users.item", "42", "43")
+	require.Error(t, err)
+	_, err = s.URL(1, "users.count")
+	require.ErrorIs(t, err, ErrUnknownRoute)
+	_, err = s.URL(2, "users")
+	require.Error(t, err)
+}
@@ -1,6 +1,8 @@
 package luddite
 
 import (
+	"context"
+	"crypto/tls"
 	"errors"
 	"fmt"
 	"io"
@@ -12,7 +14,9 @@ import (
 	"path"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"syscall"
+	"time"
 
 	"github.com/dimfeld/httptreemux"
 	"github.com/opentracing/opentracing-go"
@@ -24,19 +28,37 @@ import (
 	"golang.org/x/net/http2/h2c"
 )
 
+// ErrServiceNotRunning occurs when Shutdown is called on a service that has not
+// been run.
+var ErrServiceNotRunning = errors.New("service is not running")
+
 // Service implements a standalone RESTful web service.
 type Service struct {
-	config        *ServiceConfig
-	globalRouter  *httptreemux.ContextMux
-	apiRouters    map[int]*httptreemux.ContextMux
-	defaultLogger *log.Logger
-	accessLogger  *log.Logger
-	tracerKind    TracerKind
-	tracer        opentracing.Tracer
-	schemas       http.FileSystem
-	cors          *cors.Cors
-	handlers      []Handler
-	once          sync.Once
+	config         *ServiceConfig
+	globalRouter   *httptreemux.ContextMux
+	adminRouter    *httptreemux.ContextMux
+	apiRouters     map[int]*httptreemux.ContextMux
+	routes         map[int]map[string]string
+	defaultLogger  *log.Logger
+	accessLogger   *log.Logger
+	tracerKind     TracerKind
+	tracer         opentracing.Tracer
+	schemas        http.FileSystem
+	cors           *cors.Cors
+	handlers       []Handler
+	healthChecks   []*healthCheck
+	components     []Component
+	trustedProxies []*net.IPNet
+	ready          atomic.Bool
+	shutdownHooks  []func()
+	servers        []*http.Server
+	serverLock     sync.Mutex
+	listeners      []serviceListener
+	upgradeLock    sync.Mutex
+	shutdownOnce   sync.Once
+	shutdownDone   chan struct{}
+	shutdownErr    error
+	once           sync.Once
 }
 
 // NewService creates a new Service instance based on the given config.
@@ -64,10 +86,18 @@ func NewService(config *ServiceConfig, configExt ...*ServiceConfigExt) (*Service
 		config:        config,
 		defaultLogger: &log.Logger{Formatter: new(log.JSONFormatter)},
 		apiRouters:    make(map[int]*httptreemux.ContextMux, config.Version.Max-config.Version.Min+1),
+		routes:        make(map[int]map[string]string, config.Version.Max-config.Version.Min+1),
+		shutdownDone:  make(chan struct{}),
 	}
+	s.trustedProxies, _ = parseCIDRs(config.TrustedProxies) // validated above
 	s.globalRouter = s.newRouter()
+	if config.Admin.Enabled {
+		s.adminRouter = httptreemux.NewContextMux()
+		s.adminRouter.NotFoundHandler = s.globalRouter.NotFoundHandler
+	}
 	for v := config.Version.Min; v <= config.Version.Max; v++ {
 		s.apiRouters[v] = s.newRouter()
+		s.routes[v] = make(map[string]string)
 	}
 
 	// Configure logging
@@ -183,6 +213,17 @@ func (s *Service) Router(version int) (*httptreemux.ContextMux, error) {
 	return router, nil
 }
 
+// AdminRouter returns the router for operational endpoints such as metrics and
+// profiling. When the admin listener is enabled, routes added here are served
+// only on the admin listener; otherwise they are served on the public listener
+// alongside API routes, without regard to API version.
+func (s *Service) AdminRouter() *httptreemux.ContextMux {
+	if s.adminRouter != nil {
+		return s.adminRouter
+	}
+	return s.globalRouter
+}
+
 // AppendHandler appends a middleware handler to the service's middleware stack.
 // All handlers must be added before Run is called.
 func (s *Service) AppendHandler(h Handler) {
@@ -212,8 +253,8 @@ func (s *Service) AddResource(version int, basePath string, r interface{}) error
 		return err
 	}
 
-	s.addCollectionRoutes(router, basePath, r)
-	s.addSingletonRoutes(router, basePath, r)
+	s.addCollectionRoutes(version, router, basePath, r)
+	s.addSingletonRoutes(version, router, basePath, r)
 	return nil
 }
 
@@ -224,14 +265,106 @@ func (s *Service) SetSchemas(schemas http.FileSystem) {
 	s.schemas = schemas
 }
 
-// Run starts the service's HTTP server and runs it forever or until SIGINT is
-// received. This method should be invoked once per service.
+// RegisterOnShutdown registers a function to call when the service begins a
+// graceful shutdown. Hooks run concurrently with connection draining and are
+// typically used to notify long-lived (e.g. streaming or hijacked) connections
+// that they should close. All hooks must be registered before Run is called.
+func (s *Service) RegisterOnShutdown(f func()) {
+	s.shutdownHooks = append(s.shutdownHooks, f)
+}
+
+// Run starts the service's HTTP server and runs it forever or until SIGINT or
+// SIGTERM is received. On signal, the service stops accepting new connections
+// and waits up to the configured shutdown timeout for in-flight requests to
+// complete. A second signal during that time terminates the process
+// immediately. This method should be invoked once per service.
 func (s *Service) Run() error {
+	ctx, stop := notifySignalContext()
+	defer stop()
+	return s.RunContext(ctx)
+}
+
+// RunContext starts the service's HTTP server and runs it until ctx is done or
+// Shutdown is called. Unlike Run, it does not install any signal handlers. When
+// ctx is done, the service stops accepting new connections and waits up to the
+// configured shutdown timeout for in-flight requests to complete. Either Run or
+// RunContext should be invoked once per service.
+func (s *Service) RunContext(ctx context.Context) error {
 	err := errors.New("service instances may only be run one time")
-	s.once.Do(func() { err = s.run() })
+	s.once.Do(func() { err = s.run(ctx, nil) })
 	return err
 }
 
+// Serve runs the service on a caller-provided listener (e.g. a Unix domain
+// socket or a socket inherited from systemd) instead of listening on the
+// configured address. It otherwise behaves like Run, including SIGINT and
+// SIGTERM handling. The listener is closed when the service stops.
+func (s *Service) Serve(l net.Listener) error {
+	ctx, stop := notifySignalContext()
+	defer stop()
+	return s.ServeContext(ctx, l)
+}
+
+// notifySignalContext returns a context that is done when SIGINT or SIGTERM is
+// received. The signal handlers are removed as soon as that happens, so that a
+// second signal gets the default behavior and forces the process to exit
+// instead of waiting for in-flight requests to drain.
+func notifySignalContext() (context.Context, context.CancelFunc) {
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		<-ctx.Done()
+		stop()
+	}()
+	return ctx, stop
+}
+
+// ServeContext runs the service on a caller-provided listener until ctx is
+// done or Shutdown is called. It otherwise behaves like RunContext.
+func (s *Service) ServeContext(ctx context.Context, l net.Listener) error {
+	err := errors.New("service instances may only be run one time")
+	s.once.Do(func() { err = s.run(ctx, l) })
+	return err
+}
+
+// Shutdown gracefully shuts down a running service: it stops accepting new
+// connections and waits for in-flight requests to complete. If ctx is done
+// before draining completes, Shutdown returns the context's error while
+// remaining connections continue to drain. Run and RunContext return once
+// draining has completed.
+func (s *Service) Shutdown(ctx context.Context) error {
+	s.serverLock.Lock()
+	servers := s.servers
+	s.serverLock.Unlock()
+	if len(servers) == 0 {
+		return ErrServiceNotRunning
+	}
+
+	s.shutdownOnce.Do(func() {
+		s.ready.Store(false)
+		go func() {
+			// Servers shut down in order, so the admin server (if any)
+			// keeps serving metrics and readiness while the public
+			// server drains
+			var errs []error
+			for _, srv := range servers {
+				if err := srv.Shutdown(ctx); err != nil {
+					s.defaultLogger.WithError(err).Warn("graceful shutdown timed out, closing remaining connections")
+					errs = append(errs, srv.Close())
+				}
+			}
+			s.shutdownErr = errors.Join(errs...)
+			close(s.shutdownDone)
+		}()
+	})
+
+	select {
+	case <-s.shutdownDone:
+		return s.shutdownErr
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
 func (s *Service) newRouter() *httptreemux.ContextMux {
 	router := httptreemux.NewContextMux()
 	router.NotFoundHandler = func(rw http.ResponseWriter, _ *http.Request) { rw.WriteHeader(http.StatusNotFound) }
@@ -278,11 +411,11 @@ func (s *Service) newTracer() (kind TracerKind, tracer opentracing.Tracer) {
 
 func (s *Service) addMetricsRoute() {
 	h := promhttp.HandlerFor(prometheus.DefaultGatherer, promhttp.HandlerOpts{})
-	s.globalRouter.GET(s.config.Metrics.URIPath, h.ServeHTTP)
+	s.AdminRouter().GET(s.config.Metrics.URIPath, h.ServeHTTP)
 }
 
 func (s *Service) addProfilerRoutes() {
-	router := s.globalRouter
+	router := s.AdminRouter()
 	uriPath := path.Clean(s.config.Profiler.URIPath)
 	router.GET(strings.TrimRight(uriPath, "/")+"/", pprof.Index)
 	router.GET(path.Join(uriPath, "/allocs"), pprof.Handler("allocs").ServeHTTP)
@@ -303,13 +436,19 @@ func (s *Service) addProfilerRoutes() {
 func (s *Service) addSchemaRoutes() {
 	config := s.config
 	router := s.globalRouter
+	prefix := config.Prefix
+	if s.adminRouter != nil && config.Admin.Schema {
+		// The admin router doesn't apply the service's prefix
+		router = s.adminRouter
+		prefix = ""
+	}
 
 	// Serve the various schemas, e.g. /schema/v1, /schema/v2, etc.
 	h := newSchemaHandler(s.schemas)
 	router.GET(path.Join(config.Schema.URIPath, ":version/*filepath"), h.ServeHTTP)
 
 	// Temporarily redirect (307) the base schema path to the default schema file, e.g. /schema -> /schema/v2/fileName
-	defaultSchemaPath := path.Join(config.Prefix, config.Schema.URIPath, fmt.Sprintf("v%d", config.Version.Max), config.Schema.FileName)
+	defaultSchemaPath := path.Join(prefix, config.Schema.URIPath, fmt.Sprintf("v%d", config.Version.Max), config.Schema.FileName)
 	router.GET(config.Schema.URIPath, func(rw http.ResponseWriter, req *http.Request) {
 		http.Redirect(rw, req, defaultSchemaPath, http.StatusTemporaryRedirect)
 	})
@@ -327,43 +466,66 @@ func (s *Service) addSchemaRoutes() {
 	}
 }
 
-func (s *Service) addCollectionRoutes(router *httptreemux.ContextMux, basePath string, r interface{}) {
-	if x, ok := r.(CollectionLister); ok {
+func (s *Service) addCollectionRoutes(version int, router *httptreemux.ContextMux, basePath string, r interface{}) {
+	itemPath := path.Join(basePath, ":"+RouteParamId)
+	if x, ok := r.(CollectionPageLister); ok {
+		AddListPageCollectionRoute(router, basePath, x)
+		s.registerRoute(version, basePath, "", basePath)
+	} else if x, ok := r.(CollectionLister); ok {
 		AddListCollectionRoute(router, basePath, x)
+		s.registerRoute(version, basePath, "", basePath)
 	}
 	if x, ok := r.(CollectionCounter); ok {
 		AddCountCollectionRoute(router, basePath, x)
+		s.registerRoute(version, basePath, routeSuffixCount, path.Join(basePath, "all", "count"))
 	}
 	if x, ok := r.(CollectionGetter); ok {
 		AddGetCollectionRoute(router, basePath, x)
+		s.registerRoute(version, basePath, routeSuffixItem, itemPath)
 	}
 	if x, ok := r.(CollectionCreator); ok {
 		AddCreateCollectionRoute(router, basePath, x)
+		s.registerRoute(version, basePath, "", basePath)
 	}
 	if x, ok := r.(CollectionUpdater); ok {
 		AddUpdateCollectionRoute(router, basePath, x)
+		s.registerRoute(version, basePath, routeSuffixItem, itemPath)
+	}
+	if x, ok := r.(CollectionPatcher); ok {
+		AddPatchCollectionRoute(router, basePath, x)
+		s.registerRoute(version, basePath, routeSuffixItem, itemPath)
 	}
 	if x, ok := r.(CollectionDeleter); ok {
 		AddDeleteCollectionRoute(router, basePath, x)
+		s.registerRoute(version, basePath, "", basePath)
+		s.registerRoute(version, basePath, routeSuffixItem, itemPath)
 	}
 	if x, ok := r.(CollectionActioner); ok {
 		AddActionCollectionRoute(router, basePath, x)
+		s.registerRoute(version, basePath, routeSuffixAction, path.Join(itemPath, ":"+RouteParamAction))
 	}
 }
 
-func (s *Service) addSingletonRoutes(router *httptreemux.ContextMux, basePath string, r interface{}) {
+func (s *Service) addSingletonRoutes(version int, router *httptreemux.ContextMux, basePath string, r interface{}) {
 	if x, ok := r.(SingletonGetter); ok {
 		AddGetSingletonRoute(router, basePath, x)
+		s.registerRoute(version, basePath, "", basePath)
 	}
 	if x, ok := r.(SingletonUpdater); ok {
 		AddUpdateSingletonRoute(router, basePath, x)
+		s.registerRoute(version, basePath, "", basePath)
+	}
+	if x, ok := r.(SingletonPatcher); ok {
+		AddPatchSingletonRoute(router, basePath, x)
+		s.registerRoute(version, basePath, "", basePath)
 	}
 	if x, ok := r.(SingletonActioner); ok {
 		AddActionSingletonRoute(router, basePath, x)
+		s.registerRoute(version, basePath, routeSuffixAction, path.Join(basePath, ":"+RouteParamAction))
 	}
 }
 
-func (s *Service) run() error {
+func (s *Service) run(ctx context.Context, listener net.Listener) (err error) {
 	// Add optional HTTP handlers
 	if s.config.Metrics.Enabled {
 		s.addMetricsRoute()
@@ -371,15 +533,17 @@ func (s *Service) run() error {
 	if s.config.Profiler.Enabled {
 		s.addProfilerRoutes()
 	}
+	if s.config.Health.Enabled {
+		s.addHealthRoutes()
+	}
 	if s.config.Schema.Enabled {
 		s.addSchemaRoutes()
 	}
 
 	var (
-		listener          net.Listener
 		httpHandler       http.Handler
 		certificateLoader CertificateLoader
-		err               error
+		clientCALoader    ClientCALoader
 	)
 
 	// Add "top" as the final middleware handler
@@ -394,32 +558,239 @@ func (s *Service) run() error {
 		httpHandler = instrumentHTTPHandler(httpHandler)
 	}
 
-	// Serve HTTP or HTTPS, depending on config. Use stoppable listener, so
-	// we can exit gracefully if signaled to do so.
+	// Start components before opening listeners, and stop them once
+	// in-flight requests have drained
+	if err = s.startComponents(ctx); err != nil {
+		return err
+	}
+	defer func() {
+		err = errors.Join(err, s.stopComponents(s.components))
+	}()
+
+	// Serve HTTP or HTTPS, depending on config
+	h2s := s.newHTTP2Server()
 	if s.config.Transport.TLS {
 		if certificateLoader, err = NewCertificateLoader(s.config, s.Logger()); err != nil {
 			return err
 		}
 		defer certificateLoader.Close()
-		s.defaultLogger.Debugf("HTTPS listening on %s", s.config.Addr)
-		if listener, err = NewStoppableTLSListener(s.config.Addr, true, certificateLoader.GetCertificate); err != nil {
+		if s.config.Transport.ClientCAFilePath != "" {
+			if clientCALoader, err = NewClientCALoader(s.config, s.Logger()); err != nil {
+				return err
+			}
+			defer clientCALoader.Close()
+		}
+	}
+	if listener == nil {
+		if listener, err = s.listen("http", s.config.Addr); err != nil {
 			return err
 		}
 	} else {
-		s.defaultLogger.Debugf("HTTP listening on %s", s.config.Addr)
-		if listener, err = NewStoppableTCPListener(s.config.Addr, true); err != nil {
+		s.addListener("http", listener)
+	}
+	listeners := []net.Listener{listener}
+	defer func() {
+		if err != nil {
+			s.closeListeners()
+		}
+	}()
+	if s.config.Transport.TLS {
+		s.defaultLogger.Debugf("HTTPS listening on %s", listener.Addr())
+		var tlsConfig *tls.Config
+		if tlsConfig, err = newServiceTLSConfig(s.config, certificateLoader.GetCertificate, clientCALoader); err != nil {
 			return err
 		}
-		httpHandler = h2c.NewHandler(httpHandler, new(http2.Server))
+		listeners[0] = tls.NewListener(listener, tlsConfig)
+
+		// Optionally serve plain HTTP alongside HTTPS
+		if addr := s.config.Transport.PlaintextAddr; addr != "" {
+			if listener, err = s.listen("plaintext", addr); err != nil {
+				return err
+			}
+			s.defaultLogger.Debugf("HTTP listening on %s", listener.Addr())
+			listeners = append(listeners, listener)
+			if s.config.Transport.RedirectToTLS {
+				var tlsPort string
+				if tlsPort, err = tlsRedirectPort(s.config, listeners[0].Addr()); err != nil {
+					return err
+				}
+				httpHandler = newTLSRedirectHandler(httpHandler, tlsPort, s.config.Transport.RedirectExemptPaths)
+			}
+			httpHandler = h2c.NewHandler(httpHandler, h2s)
+		}
+	} else {
+		s.defaultLogger.Debugf("HTTP listening on %s", listener.Addr())
+		httpHandler = h2c.NewHandler(httpHandler, h2s)
 	}
 
-	if err = http.Serve(listener, httpHandler); err != nil {
+	var srv *http.Server
+	if srv, err = s.newHTTPServer(httpHandler, h2s); err != nil {
+		return err
+	}
+	for _, f := range s.shutdownHooks {
+		srv.RegisterOnShutdown(f)
+	}
+	served := make([]servedListener, 0, len(listeners)+1)
+	for _, l := range listeners {
+		served = append(served, servedListener{srv: srv, l: l})
+	}
+	servers := []*http.Server{srv}
+
+	// Optionally serve operational endpoints on a separate admin listener
+	if s.config.Admin.Enabled {
+		var adminListener net.Listener
+		if adminListener, err = s.listen("admin", s.config.Admin.Addr); err != nil {
+			return err
+		}
+		adminHandler := s.newAdminHandler()
+		adminH2s := s.newHTTP2Server()
+		if s.config.Admin.TLS {
+			s.defaultLogger.Debugf("admin HTTPS listening on %s", adminListener.Addr())
+			tlsConfig := newTLSConfig(certificateLoader.GetCertificate)
+			if err = setTLSPolicy(tlsConfig, s.config); err != nil {
+				return err
+			}
+			adminListener = tls.NewListener(adminListener, tlsConfig)
+		} else {
+			s.defaultLogger.Debugf("admin HTTP listening on %s", adminListener.Addr())
+			adminHandler = h2c.NewHandler(adminHandler, adminH2s)
+		}
+		var adminSrv *http.Server
+		if adminSrv, err = s.newHTTPServer(adminHandler, adminH2s); err != nil {
+			return err
+		}
+		served = append(served, servedListener{srv: adminSrv, l: adminListener})
+		servers = append(servers, adminSrv)
+	}
+
+	s.serverLock.Lock()
+	s.servers = servers
+	s.serverLock.Unlock()
+
+	// Gracefully shut down when the context is done
+	stopped := make(chan struct{})
+	defer close(stopped)
+	go func() {
+		select {
+		case <-ctx.Done():
+			s.defaultLogger.Info("shutting down")
+			s.shutdownWithTimeout()
+		case <-stopped:
+		}
+	}()
+	s.handleUpgradeSignals(ctx)
+
+	// If this process was started by a binary upgrade, let the parent
+	// process know that it can drain and exit
+	notifyUpgradeReady()
+
+	s.ready.Store(true)
+	return s.serve(served)
+}
+
+// listen returns the listener with the given name: either one inherited from a
+// parent process during a binary upgrade, or a new listener on addr.
+func (s *Service) listen(name, addr string) (net.Listener, error) {
+	l := takeInheritedListener(name)
+	if l != nil {
+		s.defaultLogger.Debugf("inherited %s listener on %s", name, l.Addr())
+	} else {
+		var err error
+		if l, err = Listen(addr, true); err != nil {
+			return nil, err
+		}
+	}
+	if sl, ok := l.(*StoppableTCPListener); ok {
+		sl.SetKeepAlivePeriod(time.Duration(s.config.Server.KeepAlivePeriodSeconds) * time.Second)
+		if name != "admin" {
+			sl.SetMaxConnections(s.config.Server.MaxConnections, s.config.Server.MaxConnectionsPolicy == maxConnectionsPolicyReject)
+			if s.config.ProxyProtocol.Enabled {
+				trusted, _ := parseCIDRs(s.config.ProxyProtocol.TrustedCIDRs) // validated by NewService
+				sl.SetProxyProtocol(trusted, time.Duration(s.config.ProxyProtocol.HeaderTimeoutSeconds)*time.Second)
+			}
+		}
+	}
+	s.addListener(name, l)
+	return l, nil
+}
+
+// newHTTPServer creates an HTTP server with the configured timeouts and limits.
+// HTTP/2 over TLS is served by h2s.
+func (s *Service) newHTTPServer(h http.Handler, h2s *http2.Server) (*http.Server, error) {
+	config := &s.config.Server
+	srv := &http.Server{
+		Handler:           h,
+		ReadHeaderTimeout: time.Duration(config.ReadHeaderTimeoutSeconds) * time.Second,
+		ReadTimeout:       time.Duration(config.ReadTimeoutSeconds) * time.Second,
+		WriteTimeout:      time.Duration(config.WriteTimeoutSeconds) * time.Second,
+		IdleTimeout:       time.Duration(config.IdleTimeoutSeconds) * time.Second,
+		MaxHeaderBytes:    config.MaxHeaderBytes,
+	}
+	if err := http2.ConfigureServer(srv, h2s); err != nil {
+		return nil, err
+	}
+	return srv, nil
+}
+
+// newHTTP2Server creates an HTTP/2 server with the configured limits. It is
+// used for both HTTP/2 over TLS and h2c.
+func (s *Service) newHTTP2Server() *http2.Server {
+	config := &s.config.Server.HTTP2
+	return &http2.Server{
+		MaxConcurrentStreams: config.MaxConcurrentStreams,
+		MaxReadFrameSize:     config.MaxReadFrameSize,
+	}
+}
+
+// servedListener pairs a listener with the server that serves it.
+type servedListener struct {
+	srv *http.Server
+	l   net.Listener
+}
+
+// serve serves on all listeners until the service is shut down. If any
+// listener fails or is stopped, the service shuts down.
+func (s *Service) serve(listeners []servedListener) error {
+	errs := make(chan error, len(listeners))
+	for _, sl := range listeners {
+		go func(sl servedListener) { errs <- sl.srv.Serve(sl.l) }(sl)
+	}
+
+	var serveErr error
+	for range listeners {
+		err := <-errs
 		var lse *ListenerStoppedError
-		if errors.As(err, &lse) {
-			err = nil
+		if errors.Is(err, http.ErrServerClosed) {
+			continue
+		} else if !errors.As(err, &lse) && serveErr == nil {
+			serveErr = err
 		}
+		go s.shutdownWithTimeout()
 	}
-	return err
+
+	// Wait for in-flight requests to drain, even if a listener failed
+	<-s.shutdownDone
+	if serveErr != nil {
+		return serveErr
+	}
+	return s.shutdownErr
+}
+
+func (s *Service) closeListeners() {
+	s.serverLock.Lock()
+	defer s.serverLock.Unlock()
+	for _, sl := range s.listeners {
+		_ = sl.l.Close()
+	}
+}
+
+// shutdownWithTimeout gracefully shuts down the service, waiting up to the
+// configured shutdown timeout for in-flight requests to complete.
+func (s *Service) shutdownWithTimeout() {
+	timeout := time.Duration(s.config.Shutdown.TimeoutSeconds) * time.Second
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	_ = s.Shutdown(ctx)
 }
 
 func openLogFile(logger *log.Logger, logPath string) {
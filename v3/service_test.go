@@ -0,0 +1,264 @@
+package luddite
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func newTestService(t *testing.T) *Service {
+	config := new(ServiceConfig)
+	config.Addr = "127.0.0.1:0"
+	config.Version.Min = 1
+	config.Version.Max = 1
+	s, err := NewService(config, &ServiceConfigExt{
+		ServiceLogWriter: io.Discard,
+		AccessLogWriter:  io.Discard,
+	})
+	require.NoError(t, err)
+	return s
+}
+
+func TestServiceRunContext(t *testing.T) {
+	s := newTestService(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- s.RunContext(ctx) }()
+
+	cancel()
+	select {
+	case err := <-done:
+		require.NoError(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("service did not stop when its context was canceled")
+	}
+	require.Error(t, s.RunContext(context.Background()))
+}
+
+func TestServiceShutdown(t *testing.T) {
+	s := newTestService(t)
+	require.ErrorIs(t, s.Shutdown(context.Background()), ErrServiceNotRunning)
+
+	done := make(chan error)
+	go func() { done <- s.RunContext(context.Background()) }()
+	require.Eventually(t, func() bool {
+		s.serverLock.Lock()
+		defer s.serverLock.Unlock()
+		return len(s.servers) != 0
+	}, 5*time.Second, 10*time.Millisecond)
+
+	require.NoError(t, s.Shutdown(context.Background()))
+	select {
+	case err := <-done:
+		require.NoError(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("service did not stop after Shutdown")
+	}
+}
+
+type testBlockingResource struct {
+	started chan struct{}
+	release chan struct{}
+}
+
+func (r *testBlockingResource) Get(_ *http.Request) (int, interface{}) {
+	close(r.started)
+	<-r.release
+	return http.StatusOK, "done"
+}
+
+func TestServiceListenerFailureDrains(t *testing.T) {
+	s := newTestService(t)
+	r := &testBlockingResource{started: make(chan struct{}), release: make(chan struct{})}
+	require.NoError(t, s.AddResource(1, "/block", r))
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	done := make(chan error)
+	go func() { done <- s.ServeContext(context.Background(), l) }()
+
+	res := make(chan error)
+	go func() {
+		resp, err := http.Get("http://" + l.Addr().String() + "/block")
+		if err == nil {
+			_ = resp.Body.Close()
+		}
+		res <- err
+	}()
+	<-r.started
+
+	// A failed listener shuts the service down, but in-flight requests still
+	// complete before serving returns
+	_ = l.Close()
+	select {
+	case <-done:
+		t.Fatal("service stopped before in-flight requests completed")
+	case <-time.After(100 * time.Millisecond):
+	}
+	close(r.release)
+	require.NoError(t, <-res)
+	require.Error(t, <-done)
+}
+
+type testPingResource struct{}
+
+func (r *testPingResource) Get(_ *http.Request) (int, interface{}) {
+	return http.StatusOK, "pong"
+}
+
+// testThing is a Versioned resource value used by the resource route tests.
+type testThing struct {
+	Id       string    `json:"id"`
+	Name     string    `json:"name"`
+	Size     int       `json:"size"`
+	Version  int       `json:"version"`
+	Modified time.Time `json:"-"`
+}
+
+func (v *testThing) ETag() string {
+	return "v" + strconv.Itoa(v.Version)
+}
+
+func (v *testThing) LastModified() time.Time {
+	return v.Modified
+}
+
+// testThingResource is a collection holding at most one testThing. It
+// implements every collection interface except listing and counting. Each
+// update increments the value's version and moves its modification time
+// forward by an hour.
+type testThingResource struct {
+	value   *testThing
+	updates int
+	deletes int
+}
+
+func (r *testThingResource) New() interface{} {
+	return new(testThing)
+}
+
+func (r *testThingResource) Id(value interface{}) string {
+	return value.(*testThing).Id
+}
+
+func (r *testThingResource) Get(_ *http.Request, id string) (int, interface{}) {
+	if r.value == nil || id != r.value.Id {
+		return http.StatusNotFound, nil
+	}
+	return http.StatusOK, r.value
+}
+
+func (r *testThingResource) Update(_ *http.Request, _ string, value interface{}) (int, interface{}) {
+	r.updates++
+	v := value.(*testThing)
+	v.Version = r.value.Version + 1
+	v.Modified = r.value.Modified.Add(time.Hour)
+	r.value = v
+	return http.StatusOK, v
+}
+
+func (r *testThingResource) Delete(_ *http.Request, _ string) (int, interface{}) {
+	r.deletes++
+	r.value = nil
+	return http.StatusNoContent, nil
+}
+
+// serveTestRequest serves a request with the router of an API version and
+// records the response. The request carries the service in its context, and
+// the response's Content-Type is preset to JSON as the negotiator would.
+func serveTestRequest(t *testing.T, s *Service, version int, method, target string, header http.Header, body string) *httptest.ResponseRecorder {
+	router, err := s.Router(version)
+	require.NoError(t, err)
+	req := httptest.NewRequest(method, target, strings.NewReader(body))
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	if body != "" && req.Header.Get(HeaderContentType) == "" {
+		req.Header.Set(HeaderContentType, ContentTypeJson)
+	}
+	req = req.WithContext(withHandlerDetails(req.Context(), &handlerDetails{s: s}))
+	rw := httptest.NewRecorder()
+	rw.Header().Set(HeaderContentType, ContentTypeJson)
+	router.ServeHTTP(rw, req)
+	return rw
+}
+
+func TestServiceServeUnixSocket(t *testing.T) {
+	s := newTestService(t)
+	require.NoError(t, s.AddResource(1, "/ping", new(testPingResource)))
+
+	sockPath := filepath.Join(t.TempDir(), "service.sock")
+	l, err := Listen("unix:"+sockPath, true)
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- s.ServeContext(ctx, l) }()
+
+	client := &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return new(net.Dialer).DialContext(ctx, "unix", sockPath)
+		},
+	}}
+	res, err := client.Get("http://localhost/ping")
+	require.NoError(t, err)
+	body, err := io.ReadAll(res.Body)
+	_ = res.Body.Close()
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, res.StatusCode)
+	require.Equal(t, `"pong"`, string(body))
+
+	cancel()
+	require.NoError(t, <-done)
+}
+
+func TestServiceServerLimits(t *testing.T) {
+	s := newTestService(t)
+	s.config.Server.ReadHeaderTimeoutSeconds = 1
+	s.config.Server.MaxHeaderBytes = 4096
+	s.config.Server.HTTP2.MaxConcurrentStreams = 10
+
+	h2s := s.newHTTP2Server()
+	require.EqualValues(t, 10, h2s.MaxConcurrentStreams)
+	srv, err := s.newHTTPServer(http.NotFoundHandler(), h2s)
+	require.NoError(t, err)
+	require.Equal(t, time.Second, srv.ReadHeaderTimeout)
+	require.Equal(t, 120*time.Second, srv.IdleTimeout)
+	require.Equal(t, 4096, srv.MaxHeaderBytes)
+	require.Contains(t, srv.TLSNextProto, "h2")
+
+	// A client that never finishes sending headers is disconnected
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- s.RunContext(ctx) }()
+	conn, err := net.Dial("tcp", testListenerAddr(t, s, "http").String())
+	require.NoError(t, err)
+	_, err = conn.Write([]byte("GET / HTTP/1.1\r\nHost: localhost\r\n"))
+	require.NoError(t, err)
+	require.NoError(t, conn.SetReadDeadline(time.Now().Add(5*time.Second)))
+	_, err = io.ReadAll(conn)
+	require.NoError(t, err)
+	_ = conn.Close()
+
+	cancel()
+	require.NoError(t, <-done)
+}
+
+func TestValidateServerLimits(t *testing.T) {
+	config := new(ServiceConfig)
+	config.Version.Min = 1
+	config.Version.Max = 1
+	config.Server.HTTP2.MaxReadFrameSize = 1024
+	require.ErrorIs(t, config.Validate(), ErrInvalidHTTP2MaxReadFrameSize)
+	config.Server.HTTP2.MaxReadFrameSize = 1 << 20
+	require.NoError(t, config.Validate())
+}
@@ -0,0 +1,178 @@
+package luddite
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"sync/atomic"
+)
+
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+var tlsCurves = map[string]tls.CurveID{
+	"X25519":         tls.X25519,
+	"X25519MLKEM768": tls.X25519MLKEM768,
+	"P-256":          tls.CurveP256,
+	"P-384":          tls.CurveP384,
+	"P-521":          tls.CurveP521,
+	"CurveP256":      tls.CurveP256,
+	"CurveP384":      tls.CurveP384,
+	"CurveP521":      tls.CurveP521,
+}
+
+var clientAuthTypes = map[string]tls.ClientAuthType{
+	"none":               tls.NoClientCert,
+	"request":            tls.RequestClientCert,
+	"require":            tls.RequireAnyClientCert,
+	"verify_if_given":    tls.VerifyClientCertIfGiven,
+	"require_and_verify": tls.RequireAndVerifyClientCert,
+}
+
+func newTLSConfig(getCertificate func(hi *tls.ClientHelloInfo) (*tls.Certificate, error)) *tls.Config {
+	return &tls.Config{
+		NextProtos:     []string{"http/1.1", "h2"},
+		GetCertificate: getCertificate,
+	}
+}
+
+// newServiceTLSConfig builds a TLS config according to the service's transport
+// config. The config is assumed to have been validated.
+func newServiceTLSConfig(config *ServiceConfig, getCertificate func(hi *tls.ClientHelloInfo) (*tls.Certificate, error), loader ClientCALoader) (*tls.Config, error) {
+	tlsConfig := newTLSConfig(getCertificate)
+	if err := setTLSPolicy(tlsConfig, config); err != nil {
+		return nil, err
+	}
+	clientAuth, err := parseClientAuth(config.Transport.ClientAuth)
+	if err != nil {
+		return nil, err
+	}
+	setClientAuth(tlsConfig, clientAuth, loader)
+	return tlsConfig, nil
+}
+
+// setTLSPolicy applies the service's protocol version, cipher suite, curve and
+// ALPN settings. Unset values retain Go's defaults.
+func setTLSPolicy(tlsConfig *tls.Config, config *ServiceConfig) (err error) {
+	transport := &config.Transport
+	if tlsConfig.MinVersion, err = parseTLSVersion(transport.MinVersion); err != nil {
+		return
+	}
+	if tlsConfig.MaxVersion, err = parseTLSVersion(transport.MaxVersion); err != nil {
+		return
+	}
+	if tlsConfig.MinVersion != 0 && tlsConfig.MaxVersion != 0 && tlsConfig.MinVersion > tlsConfig.MaxVersion {
+		return fmt.Errorf("TLS min version '%s' is greater than max version '%s'", transport.MinVersion, transport.MaxVersion)
+	}
+	if tlsConfig.CipherSuites, err = parseCipherSuites(transport.CipherSuites); err != nil {
+		return
+	}
+	if tlsConfig.CurvePreferences, err = parseCurves(transport.CurvePreferences); err != nil {
+		return
+	}
+	if len(transport.NextProtos) > 0 {
+		for _, proto := range transport.NextProtos {
+			if proto == "" {
+				return errors.New("TLS ALPN protocol names must not be empty")
+			}
+		}
+		tlsConfig.NextProtos = transport.NextProtos
+	}
+	return
+}
+
+// parseTLSVersion maps a version string such as "1.2" to a TLS version
+// constant. An empty string maps to 0, i.e. Go's default.
+func parseTLSVersion(name string) (uint16, error) {
+	if name == "" {
+		return 0, nil
+	}
+	version, ok := tlsVersions[name]
+	if !ok {
+		return 0, fmt.Errorf("unknown TLS version '%s'", name)
+	}
+	return version, nil
+}
+
+// parseCipherSuites maps IANA cipher suite names (e.g.
+// "TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256") to cipher suite IDs. Note that TLS
+// 1.3 cipher suites are not configurable.
+func parseCipherSuites(names []string) ([]uint16, error) {
+	if len(names) == 0 {
+		return nil, nil
+	}
+	known := make(map[string]uint16)
+	for _, cs := range tls.CipherSuites() {
+		known[cs.Name] = cs.ID
+	}
+	for _, cs := range tls.InsecureCipherSuites() {
+		known[cs.Name] = cs.ID
+	}
+	ids := make([]uint16, len(names))
+	for i, name := range names {
+		id, ok := known[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown TLS cipher suite '%s'", name)
+		}
+		ids[i] = id
+	}
+	return ids, nil
+}
+
+// parseCurves maps curve names (e.g. "X25519", "P-256") to curve IDs.
+func parseCurves(names []string) ([]tls.CurveID, error) {
+	if len(names) == 0 {
+		return nil, nil
+	}
+	ids := make([]tls.CurveID, len(names))
+	for i, name := range names {
+		id, ok := tlsCurves[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown TLS curve '%s'", name)
+		}
+		ids[i] = id
+	}
+	return ids, nil
+}
+
+// parseClientAuth maps a ServiceConfig.Transport.ClientAuth value to a
+// tls.ClientAuthType. An empty value means no client certificates.
+func parseClientAuth(name string) (tls.ClientAuthType, error) {
+	if name == "" {
+		return tls.NoClientCert, nil
+	}
+	clientAuth, ok := clientAuthTypes[name]
+	if !ok {
+		return tls.NoClientCert, fmt.Errorf("unknown TLS client auth mode '%s'", name)
+	}
+	return clientAuth, nil
+}
+
+// setClientAuth configures client certificate verification. Each handshake
+// uses the loader's current CA pool, so CA bundle reloads take effect for new
+// connections without restarting the listener.
+func setClientAuth(tlsConfig *tls.Config, clientAuth tls.ClientAuthType, loader ClientCALoader) {
+	tlsConfig.ClientAuth = clientAuth
+	if loader == nil {
+		return
+	}
+
+	// Derived configs are cached per CA pool so that session ticket keys
+	// remain stable between reloads
+	base := tlsConfig.Clone()
+	var derived atomic.Pointer[tls.Config]
+	tlsConfig.GetConfigForClient = func(_ *tls.ClientHelloInfo) (*tls.Config, error) {
+		pool := loader.ClientCAs()
+		if c := derived.Load(); c != nil && c.ClientCAs == pool {
+			return c, nil
+		}
+		c := base.Clone()
+		c.ClientCAs = pool
+		derived.Store(c)
+		return c, nil
+	}
+}
@@ -0,0 +1,194 @@
+package luddite
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+type testCert struct {
+	cert     *x509.Certificate
+	key      *ecdsa.PrivateKey
+	certPath string
+	keyPath  string
+}
+
+// newTestCert creates a certificate signed by parent (or self-signed when
+// parent is nil) and writes it to PEM files in dir.
+func newTestCert(t *testing.T, dir, commonName string, isCA bool, parent *testCert) *testCert {
+	return newTestCertValidUntil(t, dir, commonName, isCA, parent, time.Now().Add(time.Hour))
+}
+
+func newTestCertValidUntil(t *testing.T, dir, commonName string, isCA bool, parent *testCert, notAfter time.Time) *testCert {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: commonName},
+		DNSNames:              []string{commonName},
+		IPAddresses:           []net.IP{net.IPv4(127, 0, 0, 1)},
+		NotBefore:             notAfter.Add(-2 * time.Hour),
+		NotAfter:              notAfter,
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  isCA,
+	}
+	signer, signerKey := template, key
+	if parent != nil {
+		signer, signerKey = parent.cert, parent.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, signer, &key.PublicKey, signerKey)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+
+	tc := &testCert{
+		cert:     cert,
+		key:      key,
+		certPath: filepath.Join(dir, commonName+".crt"),
+		keyPath:  filepath.Join(dir, commonName+".key"),
+	}
+	require.NoError(t, os.WriteFile(tc.certPath, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600))
+	require.NoError(t, os.WriteFile(tc.keyPath, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0600))
+	return tc
+}
+
+func (tc *testCert) tlsCertificate(t *testing.T) tls.Certificate {
+	cert, err := tls.LoadX509KeyPair(tc.certPath, tc.keyPath)
+	require.NoError(t, err)
+	return cert
+}
+
+func TestParseClientAuth(t *testing.T) {
+	clientAuth, err := parseClientAuth("")
+	require.NoError(t, err)
+	require.Equal(t, tls.NoClientCert, clientAuth)
+
+	clientAuth, err = parseClientAuth("require_and_verify")
+	require.NoError(t, err)
+	require.Equal(t, tls.RequireAndVerifyClientCert, clientAuth)
+
+	_, err = parseClientAuth("sometimes")
+	require.Error(t, err)
+}
+
+func TestMutualTLS(t *testing.T) {
+	dir := t.TempDir()
+	ca := newTestCert(t, dir, "ca", true, nil)
+	server := newTestCert(t, dir, "localhost", false, ca)
+	client := newTestCert(t, dir, "client", false, ca)
+
+	config := new(ServiceConfig)
+	config.Version.Min = 1
+	config.Version.Max = 1
+	config.Transport.TLS = true
+	config.Transport.CertFilePath = server.certPath
+	config.Transport.KeyFilePath = server.keyPath
+	config.Transport.ClientCAFilePath = ca.certPath
+	accessLog := new(bytes.Buffer)
+	s, err := NewService(config, &ServiceConfigExt{
+		ServiceLogWriter: io.Discard,
+		AccessLogWriter:  accessLog,
+	})
+	require.NoError(t, err)
+	require.Equal(t, "require_and_verify", config.Transport.ClientAuth)
+	require.NoError(t, s.AddResource(1, "/ping", new(testPingResource)))
+
+	l, err := Listen("127.0.0.1:0", true)
+	require.NoError(t, err)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- s.ServeContext(ctx, l) }()
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+	url := "https://" + l.Addr().String() + "/ping"
+
+	// Clients without a certificate are rejected
+	anonClient := &http.Client{Transport: &http.Transport{
+		TLSClientConfig: &tls.Config{RootCAs: roots},
+	}}
+	_, err = anonClient.Get(url)
+	require.Error(t, err)
+
+	// Clients with a verified certificate are identified in the access log
+	clientCert := client.tlsCertificate(t)
+	mtlsClient := &http.Client{Transport: &http.Transport{
+		TLSClientConfig: &tls.Config{RootCAs: roots, Certificates: []tls.Certificate{clientCert}},
+	}}
+	res, err := mtlsClient.Get(url)
+	require.NoError(t, err)
+	_ = res.Body.Close()
+	require.Equal(t, http.StatusOK, res.StatusCode)
+
+	cancel()
+	require.NoError(t, <-done)
+	require.Contains(t, accessLog.String(), `"caller_id":"CN=client"`)
+}
+
+func TestSetTLSPolicy(t *testing.T) {
+	config := new(ServiceConfig)
+	config.Transport.MinVersion = "1.2"
+	config.Transport.MaxVersion = "1.3"
+	config.Transport.CipherSuites = []string{"TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256"}
+	config.Transport.CurvePreferences = []string{"X25519", "P-256"}
+	config.Transport.NextProtos = []string{"h2"}
+
+	tlsConfig := newTLSConfig(nil)
+	require.NoError(t, setTLSPolicy(tlsConfig, config))
+	require.Equal(t, uint16(tls.VersionTLS12), tlsConfig.MinVersion)
+	require.Equal(t, uint16(tls.VersionTLS13), tlsConfig.MaxVersion)
+	require.Equal(t, []uint16{tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256}, tlsConfig.CipherSuites)
+	require.Equal(t, []tls.CurveID{tls.X25519, tls.CurveP256}, tlsConfig.CurvePreferences)
+	require.Equal(t, []string{"h2"}, tlsConfig.NextProtos)
+}
+
+func TestValidateTLSPolicy(t *testing.T) {
+	newConfig := func() *ServiceConfig {
+		config := new(ServiceConfig)
+		config.Version.Min = 1
+		config.Version.Max = 1
+		return config
+	}
+
+	config := newConfig()
+	config.Transport.MinVersion = "1.4"
+	require.EqualError(t, config.Validate(), "unknown TLS version '1.4'")
+
+	config = newConfig()
+	config.Transport.MinVersion = "1.3"
+	config.Transport.MaxVersion = "1.2"
+	require.EqualError(t, config.Validate(), "TLS min version '1.3' is greater than max version '1.2'")
+
+	config = newConfig()
+	config.Transport.CipherSuites = []string{"TLS_RSA_WITH_ROT13"}
+	require.EqualError(t, config.Validate(), "unknown TLS cipher suite 'TLS_RSA_WITH_ROT13'")
+
+	config = newConfig()
+	config.Transport.CurvePreferences = []string{"P-255"}
+	require.EqualError(t, config.Validate(), "unknown TLS curve 'P-255'")
+
+	config = newConfig()
+	config.Transport.ClientAuth = "require_and_verify"
+	require.ErrorIs(t, config.Validate(), ErrMissingClientCAConfig)
+}
@@ -0,0 +1,102 @@
+package luddite
+
+import (
+	"errors"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	// envUpgradeListeners holds a comma-separated list of listener names
+	// passed to a new process during a binary upgrade. The listeners'
+	// descriptors begin at upgradeFirstFd, in the same order.
+	envUpgradeListeners = "LUDDITE_UPGRADE_LISTENERS"
+
+	// envUpgradeReadyFd holds the descriptor that a new process writes to
+	// once it is ready to serve requests.
+	envUpgradeReadyFd = "LUDDITE_UPGRADE_READY_FD"
+
+	// upgradeFirstFd is the first descriptor passed via exec.Cmd.ExtraFiles.
+	upgradeFirstFd = 3
+
+	defaultUpgradeReadyTimeoutSeconds = 30
+)
+
+var (
+	// ErrUpgradeInProgress occurs when an upgrade is requested while another
+	// one is still in progress.
+	ErrUpgradeInProgress = errors.New("an upgrade is already in progress")
+
+	// ErrUpgradeNotSupported occurs when upgrades are requested on a platform
+	// that does not support passing listeners to a new process.
+	ErrUpgradeNotSupported = errors.New("upgrades are not supported on this platform")
+
+	inheritOnce sync.Once
+	inheritLock sync.Mutex
+	inherited   map[string]net.Listener
+	readyFile   *os.File
+)
+
+// serviceListener is a named listener opened (or inherited) by a service. Names
+// identify listeners across a binary upgrade.
+type serviceListener struct {
+	name string
+	l    net.Listener
+}
+
+func (s *Service) addListener(name string, l net.Listener) {
+	s.serverLock.Lock()
+	s.listeners = append(s.listeners, serviceListener{name: name, l: l})
+	s.serverLock.Unlock()
+}
+
+func takeInheritedListener(name string) net.Listener {
+	inheritOnce.Do(inheritListeners)
+	inheritLock.Lock()
+	defer inheritLock.Unlock()
+	l := inherited[name]
+	delete(inherited, name)
+	return l
+}
+
+func inheritListeners() {
+	names := os.Getenv(envUpgradeListeners)
+	readyFd, _ := strconv.Atoi(os.Getenv(envUpgradeReadyFd))
+	_ = os.Unsetenv(envUpgradeListeners)
+	_ = os.Unsetenv(envUpgradeReadyFd)
+	if names == "" {
+		return
+	}
+
+	inherited = make(map[string]net.Listener)
+	for i, name := range strings.Split(names, ",") {
+		if l, err := listenFd(strconv.Itoa(upgradeFirstFd+i), true); err == nil {
+			inherited[name] = l
+		}
+	}
+	if readyFd >= upgradeFirstFd {
+		readyFile = os.NewFile(uintptr(readyFd), "upgrade-ready")
+	}
+}
+
+// notifyUpgradeReady tells the parent process, if any, that this process is
+// ready to serve requests. The parent then drains and exits. Inherited
+// listeners that this process didn't take are closed, since nothing will ever
+// serve on them.
+func notifyUpgradeReady() {
+	inheritOnce.Do(inheritListeners)
+	inheritLock.Lock()
+	defer inheritLock.Unlock()
+	for name, l := range inherited {
+		_ = l.Close()
+		delete(inherited, name)
+	}
+	if readyFile != nil {
+		_, _ = readyFile.Write([]byte{1})
+		_ = readyFile.Close()
+		readyFile = nil
+	}
+}
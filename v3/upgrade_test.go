@@ -0,0 +1,22 @@
+package luddite
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestNotifyUpgradeReadyClosesUnusedListeners(t *testing.T) {
+	inheritOnce.Do(inheritListeners)
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	inheritLock.Lock()
+	inherited = map[string]net.Listener{"plaintext": l}
+	inheritLock.Unlock()
+
+	notifyUpgradeReady()
+	require.Nil(t, takeInheritedListener("plaintext"))
+	_, err = l.Accept()
+	require.ErrorIs(t, err, net.ErrClosed)
+}
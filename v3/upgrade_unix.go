@@ -0,0 +1,146 @@
+//go:build !windows
+
+package luddite
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// Upgrade performs a zero-downtime binary upgrade. It starts a new instance of
+// the running executable, passes it the service's listening sockets, and waits
+// for the new process to become ready. The running service then stops accepting
+// connections, drains in-flight requests and returns from Run. If the new
+// process fails to become ready in time, it is killed and the running service
+// continues to serve requests.
+//
+// When ServiceConfig.Upgrade.Enabled is set, Upgrade is also invoked on
+// SIGUSR2. Upgrades are intended for processes that run a single service.
+func (s *Service) Upgrade() error {
+	if !s.upgradeLock.TryLock() {
+		return ErrUpgradeInProgress
+	}
+	defer s.upgradeLock.Unlock()
+
+	s.serverLock.Lock()
+	listeners := append([]serviceListener(nil), s.listeners...)
+	s.serverLock.Unlock()
+	if len(listeners) == 0 {
+		return ErrServiceNotRunning
+	}
+
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+
+	// Duplicate each listener's descriptor for the new process
+	var (
+		names = make([]string, len(listeners))
+		files = make([]*os.File, 0, len(listeners)+1)
+	)
+	defer func() {
+		for _, f := range files {
+			_ = f.Close()
+		}
+	}()
+	for i, sl := range listeners {
+		filer, ok := sl.l.(interface{ File() (*os.File, error) })
+		if !ok {
+			return fmt.Errorf("%s listener cannot be passed to a new process", sl.name)
+		}
+		var f *os.File
+		if f, err = filer.File(); err != nil {
+			return err
+		}
+		names[i] = sl.name
+		files = append(files, f)
+	}
+
+	// The new process signals readiness by writing to a pipe
+	readyRd, readyWr, err := os.Pipe()
+	if err != nil {
+		return err
+	}
+	defer readyRd.Close()
+	files = append(files, readyWr)
+
+	cmd := exec.Command(exe, os.Args[1:]...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	cmd.ExtraFiles = files
+	cmd.Env = append(os.Environ(),
+		envUpgradeListeners+"="+strings.Join(names, ","),
+		envUpgradeReadyFd+"="+strconv.Itoa(upgradeFirstFd+len(files)-1))
+	if err = cmd.Start(); err != nil {
+		return err
+	}
+	_ = readyWr.Close()
+	s.defaultLogger.WithField("pid", cmd.Process.Pid).Info("upgrade started, waiting for new process")
+
+	ready := make(chan error, 1)
+	go func() {
+		_, readErr := readyRd.Read(make([]byte, 1))
+		ready <- readErr
+	}()
+	exited := make(chan error, 1)
+	go func() { exited <- cmd.Wait() }()
+
+	timeout := time.Duration(s.config.Upgrade.ReadyTimeoutSeconds) * time.Second
+	select {
+	case err = <-ready:
+		if err != nil {
+			_ = cmd.Process.Kill()
+			return fmt.Errorf("new process failed to become ready: %s", err)
+		}
+	case err = <-exited:
+		return fmt.Errorf("new process exited before becoming ready: %v", err)
+	case <-time.After(timeout):
+		_ = cmd.Process.Kill()
+		return fmt.Errorf("new process did not become ready within %s", timeout)
+	}
+
+	// The new process owns the sockets now, so don't remove Unix socket
+	// files when closing our listeners
+	for _, sl := range listeners {
+		if ul, ok := sl.l.(*net.UnixListener); ok {
+			ul.SetUnlinkOnClose(false)
+		}
+	}
+	s.defaultLogger.WithField("pid", cmd.Process.Pid).Info("upgrade complete, shutting down")
+	go s.shutdownWithTimeout()
+	return nil
+}
+
+func (s *Service) handleUpgradeSignals(ctx context.Context) {
+	if !s.config.Upgrade.Enabled {
+		return
+	}
+
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGUSR2)
+	go func() {
+		defer signal.Stop(sigs)
+		for {
+			select {
+			case <-sigs:
+				if err := s.Upgrade(); err != nil {
+					s.defaultLogger.WithError(err).Error("upgrade failed")
+				}
+			case <-ctx.Done():
+				return
+			case <-s.shutdownDone:
+				return
+			}
+		}
+	}()
+}
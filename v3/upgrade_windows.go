@@ -0,0 +1,13 @@
+//go:build windows
+
+package luddite
+
+import "context"
+
+// Upgrade is not supported on Windows.
+func (s *Service) Upgrade() error {
+	return ErrUpgradeNotSupported
+}
+
+func (s *Service) handleUpgradeSignals(_ context.Context) {
+}
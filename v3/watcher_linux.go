@@ -0,0 +1,56 @@
+//go:build linux
+
+package luddite
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+
+	"golang.org/x/sys/unix"
+)
+
+const inotifyMask = unix.IN_ATTRIB | unix.IN_CLOSE_WRITE | unix.IN_CREATE | unix.IN_DELETE |
+	unix.IN_MODIFY | unix.IN_MOVED_FROM | unix.IN_MOVED_TO
+
+// newNotifyEvents watches the directories containing paths (and, for symlinks,
+// their targets) using inotify. A value is sent on the returned channel for
+// each batch of events read.
+func newNotifyEvents(paths []string) (<-chan struct{}, io.Closer, error) {
+	fd, err := unix.InotifyInit1(unix.IN_CLOEXEC | unix.IN_NONBLOCK)
+	if err != nil {
+		return nil, nil, os.NewSyscallError("inotify_init1", err)
+	}
+	// As a non-blocking file, reads use the runtime poller and are
+	// interrupted by Close
+	f := os.NewFile(uintptr(fd), "inotify")
+
+	dirs := make(map[string]struct{})
+	for _, p := range paths {
+		dirs[filepath.Dir(p)] = struct{}{}
+		if target, evalErr := filepath.EvalSymlinks(p); evalErr == nil {
+			dirs[filepath.Dir(target)] = struct{}{}
+		}
+	}
+	for dir := range dirs {
+		if _, err = unix.InotifyAddWatch(fd, dir, inotifyMask); err != nil {
+			_ = f.Close()
+			return nil, nil, os.NewSyscallError("inotify_add_watch", err)
+		}
+	}
+
+	events := make(chan struct{}, 1)
+	go func() {
+		buf := make([]byte, 4096)
+		for {
+			if _, err := f.Read(buf); err != nil {
+				return
+			}
+			select {
+			case events <- struct{}{}:
+			default:
+			}
+		}
+	}()
+	return events, f, nil
+}
@@ -0,0 +1,12 @@
+//go:build !linux
+
+package luddite
+
+import (
+	"errors"
+	"io"
+)
+
+func newNotifyEvents(_ []string) (<-chan struct{}, io.Closer, error) {
+	return nil, nil, errors.New("file notifications are not supported on this platform")
+}